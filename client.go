@@ -5,19 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 type Client struct {
 	url        string
+	key        string
 	httpClient *http.Client
 }
 
 func NewClient(url string, key string) *Client {
 	return &Client{
-		url:        url + "/send?key=" + key,
+		url:        url + "/send",
+		key:        key,
 		httpClient: http.DefaultClient,
 	}
 }
@@ -45,6 +47,7 @@ func (c *Client) SendWith(ctx context.Context, e Email, service string) (resps [
 	}
 
 	req.Header.Set("content-type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.key)
 	if len(service) > 0 {
 		req.Header.Set("X-Service", service)
 	}
@@ -62,8 +65,13 @@ func (c *Client) SendWith(ctx context.Context, e Email, service string) (resps [
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("did not get 200 from mmailer, %s", string(body))
+	if res.StatusCode != http.StatusOK {
+		merr := &Error{}
+		if json.Unmarshal(body, merr) != nil || merr.Message == "" {
+			merr.Message = strings.TrimSpace(string(body))
+		}
+		merr.Status = res.StatusCode
+		return nil, merr
 	}
 	err = json.Unmarshal(body, &resps)
 	return resps, err
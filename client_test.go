@@ -0,0 +1,39 @@
+package mmailer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Send(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer s3cret":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error":"invalid email","fields":[{"field":"to","message":"at least one recipient is required"}]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("not authorized"))
+		}
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "s3cret").Send(context.Background(), Email{})
+	var merr *Error
+	require.True(t, errors.As(err, &merr))
+	assert.Equal(t, http.StatusUnprocessableEntity, merr.Status)
+	assert.Equal(t, "invalid email", merr.Message)
+	assert.Equal(t, "to", merr.Fields[0].Field)
+
+	_, err = NewClient(srv.URL, "wrong").Send(context.Background(), Email{})
+	require.True(t, errors.As(err, &merr))
+	assert.Equal(t, http.StatusUnauthorized, merr.Status)
+	assert.Equal(t, "not authorized", merr.Message)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/attach"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+)
+
+var fetcher atomic.Pointer[attach.Fetcher]
+
+func loadFetcher() {
+	cfg := config.Get()
+	fetcher.Store(buildFetcher(cfg))
+	logger.Info(fmt.Sprintf("Attachments: fetching from hosts %v, object storage bucket %q", cfg.AttachmentHosts, cfg.S3Bucket))
+}
+
+// buildFetcher creates the fetcher of the config, it is rebuilt on reload so rotated S3 credentials are used
+func buildFetcher(cfg *config.AppConfig) *attach.Fetcher {
+	opts := attach.Options{
+		Hosts:    cfg.AttachmentHosts,
+		MaxBytes: cfg.AttachmentMaxBytes,
+		Timeout:  cfg.AttachmentFetchTimeout,
+	}
+	if cfg.S3Bucket != "" {
+		opts.S3 = &attach.S3{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyId:     cfg.S3AccessKeyId,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		}
+		if opts.S3.Endpoint == "" {
+			opts.S3.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.S3Region)
+		}
+	}
+	return attach.New(opts)
+}
+
+// resolveAttachments fetches the attachments that are references to urls or objects
+func resolveAttachments(ctx context.Context, mail mmailer.Email) (mmailer.Email, *mmailer.Error) {
+	mail, err := fetcher.Load().Resolve(ctx, mail)
+	if err == nil {
+		return mail, nil
+	}
+	logger.WarnCtx(ctx, fmt.Sprintf("could not fetch attachment: %v", err))
+	if errors.Is(err, attach.ErrTooLarge) {
+		return mail, &mmailer.Error{Status: http.StatusRequestEntityTooLarge, Message: err.Error()}
+	}
+	var aerr *attach.Error
+	if errors.As(err, &aerr) {
+		return mail, &mmailer.Error{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "could not fetch attachment",
+			Fields: []mmailer.FieldError{{
+				Field:   fmt.Sprintf("attachments[%d].%s", aerr.Index, aerr.Field),
+				Message: aerr.Err.Error(),
+			}},
+		}
+	}
+	return mail, &mmailer.Error{Status: http.StatusUnprocessableEntity, Message: err.Error()}
+}
+
+// parseForm reads a multipart/form-data send request, the email as json in the field "email" and
+// every file part as an attachment. Files are encoded as they are read, not buffered as a whole.
+func parseForm(c echo.Context) (mmailer.Email, error) {
+	mr, err := c.Request().MultipartReader()
+	if err != nil {
+		return mmailer.Email{}, err
+	}
+
+	mail := mmailer.NewEmail()
+	var hasEmail bool
+	var files []mmailer.Attachment
+	// ATTACHMENT_MAX_BYTES limits each file, and MaxAttachmentsSize all of them, as for fetched attachments
+	perFile := config.Get().AttachmentMaxBytes
+	if perFile <= 0 {
+		perFile = mmailer.MaxAttachmentsSize
+	}
+	var budget int64 = mmailer.MaxAttachmentsSize
+	for {
+		p, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return mmailer.Email{}, err
+		}
+
+		switch {
+		case p.FormName() == "email":
+			err = json.NewDecoder(p).Decode(&mail)
+			if err != nil {
+				return mmailer.Email{}, fmt.Errorf("could not unmarshal json: %w", err)
+			}
+			hasEmail = true
+		case p.FileName() != "":
+			content, head, err := attach.Encode(p, min(perFile, budget))
+			if err != nil {
+				return mmailer.Email{}, fmt.Errorf("file %s: %w", p.FileName(), err)
+			}
+			budget -= int64(base64.StdEncoding.DecodedLen(len(content)))
+			files = append(files, mmailer.Attachment{
+				Name:        strings.NewReplacer("\r", "", "\n", "", `"`, "").Replace(p.FileName()),
+				Content:     content,
+				ContentType: attach.ContentType(p.Header.Get("Content-Type"), head),
+			})
+		}
+		_ = p.Close()
+	}
+	if !hasEmail {
+		return mmailer.Email{}, errors.New(`the form has no "email" field`)
+	}
+	mail.Attachments = append(mail.Attachments, files...)
+	return mail, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/mmailer/internal/attach"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm_MaxBytesPerFile(t *testing.T) {
+	config.Set(&config.AppConfig{AttachmentMaxBytes: 10})
+	form := func(files ...string) echo.Context {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		require.NoError(t, w.WriteField("email", `{"subject": "files"}`))
+		for i, content := range files {
+			fw, err := w.CreateFormFile("file", string(rune('a'+i))+".txt")
+			require.NoError(t, err)
+			_, _ = fw.Write([]byte(content))
+		}
+		require.NoError(t, w.Close())
+		req := httptest.NewRequest(http.MethodPost, "/send", &body)
+		req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+		return echo.New().NewContext(req, httptest.NewRecorder())
+	}
+
+	mail, err := parseForm(form(strings.Repeat("a", 8), strings.Repeat("b", 8)))
+	require.NoError(t, err)
+	assert.Len(t, mail.Attachments, 2, "the limit is per file, not of all of them")
+
+	_, err = parseForm(form(strings.Repeat("a", 12)))
+	assert.ErrorIs(t, err, attach.ErrTooLarge)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/auth"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+)
+
+var apiKeys atomic.Pointer[auth.Keys]
+
+func loadKeys() {
+	keys, err := buildKeys(config.Get())
+	if err != nil {
+		logger.Error(err, "invalid API_KEY or API_KEYS")
+		os.Exit(1)
+	}
+	apiKeys.Store(&keys)
+}
+
+// currentKeys returns the api keys of the current config
+func currentKeys() auth.Keys {
+	return *apiKeys.Load()
+}
+
+func buildKeys(cfg *config.AppConfig) (auth.Keys, error) {
+	var keys []*auth.Key
+	if cfg.APIKey != "" {
+		keys = append(keys, &auth.Key{Name: "default", Secret: cfg.APIKey})
+	}
+	for i, s := range cfg.APIKeys {
+		k, err := auth.ParseKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse row %d of API_KEYS: %w", i, err)
+		}
+		keys = append(keys, k)
+	}
+	for _, c := range cfg.Keys {
+		k, err := auth.NewKey(c.Name, c.Secret, c.From, c.Services, c.Rate, c.Burst)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", c.Source, c.Name, err)
+		}
+		keys = append(keys, k)
+	}
+	apiKeys, err := auth.NewKeys(keys...)
+	if err != nil {
+		return nil, err
+	}
+	if len(apiKeys) == 0 {
+		logger.Warn("no API_KEY or API_KEYS configured, every send request will be refused")
+	}
+	for _, k := range apiKeys {
+		logger.Redact(k.Secret)
+		logger.Info(fmt.Sprintf("API key %s enabled, from domains: %v, services: %v", k.Name, k.FromDomains, k.Services))
+	}
+	return apiKeys, nil
+}
+
+// requireKey authenticates the request by its api key, and enforces the rate limit of the key
+func requireKey(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, ok := currentKeys().Lookup(auth.FromRequest(c.Request()))
+		if !ok {
+			return sendError(c, http.StatusUnauthorized, "not authorized")
+		}
+		ctx := auth.WithKey(c.Request().Context(), key)
+		ctx = logger.AddToLogContext(ctx, "api_key", key.Name)
+		c.SetRequest(c.Request().WithContext(ctx))
+
+		if !key.Allow() {
+			logger.WarnCtx(ctx, "api key rate limit exceeded")
+			return sendError(c, http.StatusTooManyRequests, "rate limit exceeded")
+		}
+		return next(c)
+	}
+}
+
+// authorizeSend checks that the key of the request may send the email, and returns
+// f restricted to the services the key may use
+func authorizeSend(ctx context.Context, f *mmailer.Facade, mail mmailer.Email, preferredService string) (*mmailer.Facade, error) {
+	key, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("request is not authenticated")
+	}
+	if !key.AllowFrom(mail.From.Email) {
+		return nil, errors.New("api key is not allowed to send from this address")
+	}
+	if preferredService != "" && !key.AllowService(preferredService) {
+		return nil, errors.New("api key is not allowed to use this service")
+	}
+	if len(key.Services) == 0 {
+		return f, nil
+	}
+	services := slicez.Filter(f.Services, func(s mmailer.Service) bool {
+		return key.AllowService(s.Name())
+	})
+	restricted := mmailer.New(f.Selecting, f.Retry, services...)
+	restricted.Routing = f.Routing
+	return restricted, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/modfin/mmailer/internal/routing"
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `usage: mmailerd config <command>
+
+commands:
+  validate  checks the config of the environment and CONFIG_FILE, and prints every problem
+  migrate   prints PROVIDERS, as yaml, of the legacy SERVICES and SERVICE_DOMAIN_API_KEYS
+`
+
+// configCommand runs mmailerd config, it returns the exit code
+func configCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+	// The services log what they are configured with, which is not of interest here
+	logger.InitializeLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	switch args[0] {
+	case "validate":
+		if err := validateConfig(); err != nil {
+			printErrors(stderr, err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "config is valid")
+		return 0
+	case "migrate":
+		// the references to secrets are kept, so that they are not printed
+		cfg, err := config.LoadReferences()
+		if err != nil {
+			printErrors(stderr, err)
+			return 1
+		}
+		out, err := yaml.Marshal(map[string][]config.Provider{"PROVIDERS": cfg.Providers})
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		_, _ = stdout.Write(out)
+		return 0
+	default:
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+}
+
+// validateConfig builds what mmailerd is started with from the config, without starting it
+func validateConfig() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	sandboxBox = mailbox.New(cfg.SandboxCapacity)
+	localBox = mailbox.New(cfg.LocalInboxCapacity)
+
+	var errs []error
+	if _, err := buildKeys(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("API_KEYS: %w", err))
+	}
+	f, err := buildFacade(cfg)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.RoutingRules != "" {
+		rules, err := routing.Load(cfg.RoutingRules)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", cfg.RoutingRules, err))
+		case f != nil:
+			if err := rules.Validate(serviceNames(f)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", cfg.RoutingRules, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// printErrors prints each of the joined errors on a line of its own
+func printErrors(w io.Writer, err error) {
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintln(w, line)
+	}
+}
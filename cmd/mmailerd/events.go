@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/stream"
+	"github.com/modfin/mmailer/internal/svc"
+	"golang.org/x/net/websocket"
+)
+
+// events is the live stream of posthooks and send attempts
+var events = stream.NewHub()
+
+const eventBuffer = 256
+const eventHeartbeat = 15 * time.Second
+
+func publishSend(ctx context.Context, a svc.Attempt) {
+	s := stream.Send{
+		Service:    a.Service,
+		From:       a.Email.From.Email,
+		Recipients: slicez.Map(slicez.Concat(a.Email.To, a.Email.Cc), func(a mmailer.Address) string { return a.Email }),
+		MessageIds: slicez.Map(a.Responses, func(r mmailer.Response) string { return r.MessageId }),
+		DurationMs: a.Duration.Milliseconds(),
+	}
+	if a.Err != nil {
+		s.Error = a.Err.Error()
+	}
+	events.Publish(stream.SendEvent(s))
+}
+
+func publishPosthooks(hooks []mmailer.Posthook) {
+	events.Publish(slicez.Map(hooks, stream.PosthookEvent)...)
+}
+
+func eventFilter(c echo.Context) stream.Filter {
+	var types []string
+	for _, t := range strings.Split(c.QueryParam("event"), ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" {
+			types = append(types, t)
+		}
+	}
+	return stream.Filter{
+		MessageId: c.QueryParam("message_id"),
+		Recipient: c.QueryParam("recipient"),
+		Service:   c.QueryParam("service"),
+		Events:    types,
+	}
+}
+
+// eventStream streams live events as server-sent events
+func eventStream(c echo.Context) error {
+	ch, unsubscribe := events.Subscribe(eventFilter(c), eventBuffer)
+	defer unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return nil
+			}
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				logger.Error(err, "could not marshal event")
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			if err != nil {
+				return nil
+			}
+		}
+		w.Flush()
+	}
+}
+
+// eventSocket streams live events as json messages over a websocket
+func eventSocket(c echo.Context) error {
+	filter := eventFilter(c)
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		ch, unsubscribe := events.Subscribe(filter, eventBuffer)
+		defer unsubscribe()
+
+		// The stream is one way, reading is only done to notice when the client goes away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var discard string
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case e := <-ch:
+				if err := websocket.JSON.Send(ws, e); err != nil {
+					return
+				}
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
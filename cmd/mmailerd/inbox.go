@@ -0,0 +1,108 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/mailbox"
+)
+
+// localBox holds the emails delivered by the local service
+var localBox *mailbox.Mailbox
+
+//go:embed inbox.html
+var inboxHTML string
+
+var inboxTemplates = template.Must(template.New("inbox").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(inboxHTML))
+
+const inboxBase = "/inbox"
+
+// emitLocalPosthooks passes the synthetic posthooks of the local service through the same pipeline as vendor posthooks
+func emitLocalPosthooks(hooks []mmailer.Posthook) {
+	err := processPosthooks(hooks)
+	if err != nil {
+		logger.Error(err, "could not process local posthooks")
+	}
+}
+
+func inboxList(c echo.Context) error {
+	recipient := c.QueryParam("recipient")
+	return inboxRender(c, "list", map[string]any{
+		"Base":      inboxBase,
+		"Recipient": recipient,
+		"Messages":  localBox.List(recipient),
+	})
+}
+
+func inboxMessage(c echo.Context) error {
+	msg, ok := localBox.Get(c.Param("id"))
+	if !ok {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	return inboxRender(c, "message", map[string]any{
+		"Base":    inboxBase,
+		"Message": msg,
+	})
+}
+
+// inboxHTMLPart serves the html of a message, it is shown in a sandboxed iframe and is not allowed to run scripts
+func inboxHTMLPart(c echo.Context) error {
+	msg, ok := localBox.Get(c.Param("id"))
+	if !ok {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	c.Response().Header().Set("Content-Security-Policy", "sandbox; script-src 'none'")
+	return c.HTML(http.StatusOK, msg.Email.Html)
+}
+
+func inboxRaw(c echo.Context) error {
+	msg, ok := localBox.Get(c.Param("id"))
+	if !ok {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	return c.Blob(http.StatusOK, "text/plain; charset=utf-8", msg.Raw)
+}
+
+func inboxAttachment(c echo.Context) error {
+	msg, ok := localBox.Get(c.Param("id"))
+	n, err := strconv.Atoi(c.Param("n"))
+	if !ok || err != nil || n < 0 || n >= len(msg.Email.Attachments) {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	a := msg.Email.Attachments[n]
+	data, err := base64.StdEncoding.DecodeString(a.Content)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "could not decode attachment")
+	}
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})
+	if disposition == "" {
+		// the name can't be represented, eg. it has control characters
+		disposition = "attachment"
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, disposition)
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+func inboxRender(c echo.Context, name string, data map[string]any) error {
+	var b strings.Builder
+	err := inboxTemplates.ExecuteTemplate(&b, name, data)
+	if err != nil {
+		logger.Error(err, "could not render inbox")
+		return c.String(http.StatusInternalServerError, "internal server error")
+	}
+	return c.HTML(http.StatusOK, b.String())
+}
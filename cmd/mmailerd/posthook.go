@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/dedup"
+	"github.com/modfin/mmailer/internal/forward"
+	"github.com/modfin/mmailer/internal/logger"
+)
+
+var forwarder *forward.Forwarder
+var deduper *dedup.Deduper
+
+func loadDeduper() {
+	if !config.Get().PosthookDedup {
+		logger.Info("Posthook deduplication: disabled")
+		return
+	}
+	ttl := config.Get().PosthookDedupTTL
+	capacity := config.Get().PosthookDedupCapacity
+	if config.Get().PosthookDedupFile == "" {
+		logger.Info(fmt.Sprintf("Posthook deduplication: in memory, ttl %v", ttl))
+		deduper = dedup.New(dedup.NewMemStore(ttl, capacity))
+		return
+	}
+	store, err := dedup.NewFileStore(config.Get().PosthookDedupFile, ttl, capacity)
+	if err != nil {
+		logger.Error(err, "could not open posthook deduplication store")
+		os.Exit(1)
+	}
+	logger.Info(fmt.Sprintf("Posthook deduplication: %s, ttl %v", config.Get().PosthookDedupFile, ttl))
+	deduper = dedup.New(store)
+}
+
+func loadForwarder() {
+	sinks := slices.Concat(config.Get().PosthookForward, config.Get().PosthookSinks)
+	if len(sinks) == 0 {
+		logger.Info("Posthooks will not be forwarded, no POSTHOOK_FORWARD or POSTHOOK_SINKS configured")
+		return
+	}
+	var targets []forward.Target
+	for _, s := range sinks {
+		t, err := forward.ParseSink(s, forward.SinkDefaults{
+			Secret: func() string {
+				return config.Get().PosthookSecret
+			},
+			Timeout: config.Get().PosthookTimeout,
+		})
+		if err != nil {
+			logger.Error(err, "could not create posthook sink")
+			os.Exit(1)
+		}
+		targets = append(targets, t)
+		logger.Info(fmt.Sprintf("Posthooks will be forwarded to sink %s, events: %v, services: %v", t.Sink.Name(), t.Filter.Events, t.Filter.Services))
+	}
+	if config.Get().PosthookSpoolDir == "" {
+		logger.Warn("posthook queue is kept in memory, pending events are lost on restart, set POSTHOOK_SPOOL_DIR to persist it")
+	}
+
+	var err error
+	forwarder, err = forward.New(forward.Options{
+		BatchSize:   config.Get().PosthookBatchSize,
+		BatchWait:   config.Get().PosthookBatchWait,
+		MaxAttempts: config.Get().PosthookMaxAttempts,
+		MaxBackoff:  config.Get().PosthookMaxBackoff,
+		Timeout:     config.Get().PosthookTimeout,
+		Dir:         config.Get().PosthookSpoolDir,
+	}, targets...)
+	if err != nil {
+		logger.Error(err, "could not create posthook forwarder")
+		os.Exit(1)
+	}
+	if path := config.Get().PosthookRoutes; path != "" {
+		err = loadRoutes(path)
+		if err != nil {
+			logger.Error(err, "could not load posthook routing rules")
+			os.Exit(1)
+		}
+		watchFile(path, func() {
+			err := loadRoutes(path)
+			if err != nil {
+				logger.Error(err, "could not reload posthook routing rules, keeping the current ones")
+			}
+		})
+	}
+	forwarder.Start()
+}
+
+func loadRoutes(path string) error {
+	rules, err := forward.LoadRules(path)
+	if err != nil {
+		return err
+	}
+	err = forwarder.SetRules(rules)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Posthook routing rules loaded from %s, %d rule(s)", path, len(rules.Rules)))
+	return nil
+}
+
+func posthook(c echo.Context) error {
+	key := c.QueryParam("key")
+	if subtle.ConstantTimeCompare([]byte(key), []byte(config.Get().PosthookKey)) == 0 {
+		return c.String(http.StatusUnauthorized, "not authorized")
+	}
+
+	hook, err := facade().UnmarshalPosthook(c.Request())
+	if err != nil {
+		logger.Error(err, "could not unmarshal posthook")
+		return c.String(http.StatusOK, "ok")
+	}
+	markShadow(facade(), hook)
+	logger.Info(fmt.Sprintf("Posthook: %+v", hook))
+
+	// The events are delivered from the queue in the background, the vendor only has to
+	// redeliver if we could not queue them.
+	err = processPosthooks(hook)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "internal server error")
+	}
+	return c.String(http.StatusOK, "ok")
+}
+
+// processPosthooks deduplicates the events, publishes them on the live stream and queues them for forwarding.
+// The events of the shadow service are only published, unless SHADOW_POSTHOOKS is set.
+func processPosthooks(hook []mmailer.Posthook) error {
+	var err error
+	if deduper != nil {
+		received := len(hook)
+		hook, err = deduper.Filter(hook)
+		if err != nil {
+			logger.Error(err, "could not deduplicate posthook")
+			return err
+		}
+		if dropped := received - len(hook); dropped > 0 {
+			logger.Info(fmt.Sprintf("dropped %d duplicate posthook event(s)", dropped))
+		}
+	}
+	publishPosthooks(hook)
+
+	if !config.Get().ShadowPosthooks {
+		hook = slicez.Reject(hook, func(h mmailer.Posthook) bool {
+			return h.Shadow
+		})
+	}
+	if forwarder == nil {
+		logger.Info("no forwarding posthook configured, ignoring")
+		return nil
+	}
+
+	err = forwarder.Enqueue(hook)
+	if err != nil {
+		logger.Error(err, "could not queue posthook for forwarding")
+		if deduper != nil {
+			// so the redelivery isn't dropped as a duplicate, the events that were queued are still
+			// duplicates when redelivered
+			failed := hook
+			var eerr *forward.EnqueueError
+			if errors.As(err, &eerr) {
+				failed = eerr.Failed
+			}
+			if err := deduper.Forget(failed); err != nil {
+				logger.Error(err, "could not forget deduplicated posthook")
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func posthookDeadLetters(c echo.Context) error {
+	if forwarder == nil {
+		return c.JSON(http.StatusOK, []forward.DeadLetters{})
+	}
+	dead, err := forwarder.Dead()
+	if err != nil {
+		logger.Error(err, "could not list posthook dead letters")
+		return c.String(http.StatusInternalServerError, "internal server error")
+	}
+	return c.JSON(http.StatusOK, dead)
+}
+
+func posthookRevive(c echo.Context) error {
+	if forwarder == nil {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	err := forwarder.Revive(c.Param("target"), c.Param("id"))
+	if errors.Is(err, forward.ErrNotFound) {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	if err != nil {
+		logger.Error(err, "could not requeue posthook dead letter")
+		return c.String(http.StatusInternalServerError, "internal server error")
+	}
+	return c.String(http.StatusOK, "ok")
+}
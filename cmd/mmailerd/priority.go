@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/priority"
+)
+
+// class is how the emails of a priority are sent
+type class struct {
+	pool *priority.Pool
+	// services are the names of the services the class may use, empty is any
+	services []string
+	// retry is the retry strategy of the class, nil is the one of the facade
+	retry mmailer.RetryStrategy
+}
+
+// pools are started once, their size is not changed by reloading the config
+var pools = map[mmailer.Priority]*priority.Pool{}
+
+var classes atomic.Pointer[map[mmailer.Priority]class]
+
+func loadPriorities() {
+	for _, p := range mmailer.Priorities {
+		cfg := config.Get().GetPriorityClass(string(p))
+		pools[p] = priority.NewPool(string(p), cfg.Workers, cfg.Queue)
+		logger.Info(fmt.Sprintf("Priority %s: %d workers, queue of %d", p, cfg.Workers, cfg.Queue))
+	}
+	cs := buildClasses(config.Get(), facade())
+	classes.Store(&cs)
+}
+
+// buildClasses returns the services and retry strategy of each priority class, among the services of the facade
+func buildClasses(cfg *config.AppConfig, f *mmailer.Facade) map[mmailer.Priority]class {
+	names := serviceNames(f)
+	cs := map[mmailer.Priority]class{}
+	for _, p := range mmailer.Priorities {
+		pc := cfg.GetPriorityClass(string(p))
+		c := class{pool: pools[p]}
+		for _, name := range pc.Services {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !slicez.Contains(names, name) {
+				logger.Warn(fmt.Sprintf("Priority %s: ignoring %s, it is not among the services", p, name))
+				continue
+			}
+			c.services = append(c.services, name)
+		}
+		retry := "RETRY_STRATEGY"
+		if pc.Retry != "" {
+			c.retry = retryStrategy(cfg, pc.Retry)
+			retry = retryName(pc.Retry)
+		}
+		services := "any service"
+		if len(c.services) > 0 {
+			services = strings.Join(c.services, ", ")
+		}
+		logger.Info(fmt.Sprintf("Priority %s: sent by %s, retry strategy %s", p, services, retry))
+		cs[p] = c
+	}
+	return cs
+}
+
+// sendByClass sends the email by a worker of its priority class, with the services and retry strategy of the class.
+// It returns priority.ErrQueueFull if the class has too many emails waiting.
+func sendByClass(ctx context.Context, f *mmailer.Facade, mail mmailer.Email, preferredService string) (res []mmailer.Response, err error) {
+	p := mail.Priority.Class()
+	ctx = logger.AddToLogContext(ctx, "priority", p)
+	var c class
+	var ok bool
+	if cs := classes.Load(); cs != nil {
+		c, ok = (*cs)[p]
+	}
+	if !ok {
+		return f.Send(ctx, mail, preferredService)
+	}
+
+	if len(c.services) > 0 || c.retry != nil {
+		services := f.Services
+		if len(c.services) > 0 {
+			services = slicez.Filter(services, func(s mmailer.Service) bool {
+				return slicez.Contains(c.services, s.Name())
+			})
+		}
+		cf := mmailer.New(f.Selecting, f.Retry, services...)
+		cf.Routing = f.Routing
+		if c.retry != nil {
+			cf.Retry = c.retry
+		}
+		f = cf
+	}
+
+	perr := c.pool.Do(ctx, func(ctx context.Context) {
+		res, err = f.Send(ctx, mail, preferredService)
+	})
+	if perr != nil {
+		return nil, fmt.Errorf("priority %s: %w", p, perr)
+	}
+	return res, err
+}
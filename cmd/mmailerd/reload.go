@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/watch"
+)
+
+var (
+	reloadMu  sync.Mutex
+	reloaders []func()
+)
+
+// onReload registers f to be called on SIGHUP
+func onReload(f func()) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloaders = append(reloaders, f)
+}
+
+// watchFile calls reload when path changes, and on SIGHUP
+func watchFile(path string, reload func()) {
+	onReload(reload)
+	go watch.File(context.Background(), path, config.Get().WatchInterval, reload)
+}
+
+// configMu serializes config reloads, which may be triggered by both the file changing and SIGHUP
+var configMu sync.Mutex
+
+// watchConfig reloads the config when CONFIG_FILE changes, and on SIGHUP
+func watchConfig() {
+	path := config.Get().ConfigFile
+	if path == "" {
+		return
+	}
+	logger.Info(fmt.Sprintf("Config: reloading %s when it changes", path))
+	watchFile(path, reloadConfig)
+}
+
+// reloadConfig loads the config and swaps in the services, api keys, priority classes and attachment fetcher
+// built from it. Posthook sinks read POSTHOOK_FORWARD_SECRET by config.Get, so they follow the swap.
+// Sends in flight finish with the services they started with, and api keys with an unchanged rate limit keep
+// their limiters. If the config is invalid, the current one is kept.
+func reloadConfig() {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Error(err, "could not reload config, keeping the current one")
+		return
+	}
+	keys, err := buildKeys(cfg)
+	if err != nil {
+		logger.Error(err, "invalid API_KEY or API_KEYS, keeping the current config")
+		return
+	}
+	f, err := buildFacade(cfg)
+	if err != nil {
+		logger.Error(err, "invalid services, keeping the current config")
+		return
+	}
+	if rules := routes.Load(); rules != nil {
+		if err := rules.Validate(serviceNames(f)); err != nil {
+			logger.Error(err, "the routing rules refer to removed services, keeping the current config")
+			return
+		}
+	}
+	cs := buildClasses(cfg, f)
+	keys.KeepLimiters(currentKeys())
+
+	config.Set(cfg)
+	apiKeys.Store(&keys)
+	facades.Store(f)
+	classes.Store(&cs)
+	fetcher.Store(buildFetcher(cfg))
+	logger.Info("Config reloaded")
+}
+
+// watchSecrets reloads the config when a secret it refers to changes, checked every SECRETS_REFRESH
+func watchSecrets() {
+	interval := config.Get().SecretsRefresh
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			changed, err := config.Get().SecretsChanged(ctx)
+			cancel()
+			if err != nil {
+				logger.Error(err, "could not refresh secrets")
+			}
+			if changed {
+				logger.Info("Secrets changed, reloading the config")
+				reloadConfig()
+			}
+		}
+	}()
+}
+
+func handleReloadSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			logger.Info("Got SIGHUP, reloading")
+			reloadMu.Lock()
+			fs := append([]func(){}, reloaders...)
+			reloadMu.Unlock()
+			for _, f := range fs {
+				f()
+			}
+		}
+	}()
+}
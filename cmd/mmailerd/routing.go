@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/routing"
+)
+
+var routes atomic.Pointer[routing.Rules]
+
+func loadRouting() {
+	path := config.Get().RoutingRules
+	if path == "" {
+		logger.Info("Routing: none, no ROUTING_RULES configured")
+		return
+	}
+	err := reloadRouting(path)
+	if err != nil {
+		logger.Error(err, "could not load routing rules")
+		os.Exit(1)
+	}
+	watchFile(path, func() {
+		err := reloadRouting(path)
+		if err != nil {
+			logger.Error(err, "could not reload routing rules, keeping the current ones")
+		}
+	})
+}
+
+func reloadRouting(path string) error {
+	rules, err := routing.Load(path)
+	if err != nil {
+		return err
+	}
+	err = rules.Validate(serviceNames(facade()))
+	if err != nil {
+		return fmt.Errorf("invalid routing rules %s: %w", path, err)
+	}
+	routes.Store(rules)
+	logger.Info(fmt.Sprintf("Routing rules loaded from %s, %d rule(s)", path, len(rules.Rules)))
+	return nil
+}
+
+func route(email mmailer.Email, services []mmailer.Service) []mmailer.Service {
+	rules := routes.Load()
+	if rules == nil {
+		return services
+	}
+	return rules.Route(email, services)
+}
+
+// routingExplain shows how the email of the body would be routed, without sending it
+func routingExplain(c echo.Context) error {
+	b, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, "could not read body")
+	}
+	mail := mmailer.NewEmail()
+	err = json.Unmarshal(b, &mail)
+	if err != nil {
+		return sendError(c, http.StatusBadRequest, "could not unmarshal json: "+err.Error())
+	}
+
+	available := slicez.Filter(facade().Services, func(s mmailer.Service) bool {
+		return s.CanSend(mail)
+	})
+	ex := routing.Explanation{Candidates: slicez.Map(available, func(s mmailer.Service) string {
+		return s.Name()
+	})}
+	if rules := routes.Load(); rules != nil {
+		ex = rules.Explain(mail, available)
+	}
+	return c.JSON(http.StatusOK, ex)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/mmailer/internal/mailbox"
+)
+
+// sandboxBox holds the emails captured by the sandbox service and by dry runs
+var sandboxBox *mailbox.Mailbox
+
+func sandboxMessages(c echo.Context) error {
+	return c.JSON(http.StatusOK, sandboxBox.List(c.QueryParam("recipient")))
+}
+
+// sandboxMessage returns a captured message as json, or as the raw MIME message if the id ends with .eml
+func sandboxMessage(c echo.Context) error {
+	id, eml := strings.CutSuffix(c.Param("id"), ".eml")
+	msg, ok := sandboxBox.Get(id)
+	if !ok {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	if eml {
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+msg.Id+`.eml"`)
+		return c.Blob(http.StatusOK, "message/rfc822", msg.Raw)
+	}
+	return c.JSON(http.StatusOK, msg)
+}
+
+func sandboxClear(c echo.Context) error {
+	sandboxBox.Clear()
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/attach"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/priority"
+	"github.com/modfin/mmailer/internal/smtpx"
+	"github.com/modfin/mmailer/internal/svc"
+)
+
+func send(c echo.Context) error {
+	ctx := c.Request().Context()
+	logger.InfoCtx(ctx, "Received send email request")
+
+	var mail mmailer.Email
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		var err error
+		mail, err = parseForm(c)
+		if errors.Is(err, attach.ErrTooLarge) {
+			logger.WarnCtx(ctx, err.Error())
+			return sendError(c, http.StatusRequestEntityTooLarge, err.Error())
+		}
+		if err != nil {
+			logger.WarnCtx(ctx, fmt.Sprintf("could not read form: %v", err))
+			return sendError(c, http.StatusBadRequest, "could not read form: "+err.Error())
+		}
+	} else {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			logger.ErrorCtx(ctx, err, "could not read body")
+			return sendError(c, http.StatusBadRequest, "could not read body")
+		}
+
+		mail = mmailer.NewEmail()
+		err = json.Unmarshal(b, &mail)
+		if err != nil {
+			logger.WarnCtx(ctx, fmt.Sprintf("could not unmarshal json: %v", err))
+			return sendError(c, http.StatusBadRequest, "could not unmarshal json: "+err.Error())
+		}
+	}
+
+	return respond(c, mail)
+}
+
+// sendRaw sends the body as a raw MIME message, to the recipients in its To and Cc headers
+// or, if given, the comma separated addresses of the to query parameter.
+func sendRaw(c echo.Context) error {
+	ctx := c.Request().Context()
+	logger.InfoCtx(ctx, "Received send raw email request")
+
+	b, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "could not read body")
+		return sendError(c, http.StatusBadRequest, "could not read body")
+	}
+
+	mail, err := smtpx.Envelope(b)
+	if err != nil {
+		logger.WarnCtx(ctx, fmt.Sprintf("could not parse message: %v", err))
+		return sendError(c, http.StatusBadRequest, "could not parse message: "+err.Error())
+	}
+	if to := c.QueryParam("to"); to != "" {
+		mail.To, mail.Cc = nil, nil
+		for _, a := range strings.Split(to, ",") {
+			mail.To = append(mail.To, mmailer.Address{Email: strings.TrimSpace(a)})
+		}
+	}
+
+	return respond(c, mail)
+}
+
+// reported is the response of a send with ?report=true
+type reported struct {
+	Responses []mmailer.Response  `json:"responses"`
+	Report    *mmailer.SendReport `json:"report"`
+}
+
+// respond delivers the email and responds with its result, and the services tried if the report query
+// parameter is set
+func respond(c echo.Context, mail mmailer.Email) error {
+	ctx := dryRun(c)
+	var report *mmailer.SendReport
+	if want, _ := strconv.ParseBool(c.QueryParam("report")); want {
+		ctx, report = mmailer.WithReport(ctx)
+	}
+
+	res, merr := deliver(ctx, mail, c.Request().Header.Get("X-Service"))
+	if merr != nil {
+		merr.Report = report
+		return c.JSON(merr.Status, merr)
+	}
+	if report != nil {
+		return c.JSON(http.StatusOK, reported{Responses: res, Report: report})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// dryRun returns the request context, marked as a dry run if the X-Dry-Run header is set
+func dryRun(c echo.Context) context.Context {
+	ctx := c.Request().Context()
+	if dry, _ := strconv.ParseBool(c.Request().Header.Get("X-Dry-Run")); dry {
+		ctx = svc.DryRun(ctx)
+		ctx = logger.AddToLogContext(ctx, "dry_run", true)
+	}
+	return ctx
+}
+
+// deliver validates the email, checks it against the api key and tenant of the context, and sends it.
+// It is shared by every way of submitting email, errors carry the http status they map to.
+func deliver(ctx context.Context, mail mmailer.Email, preferredService string) ([]mmailer.Response, *mmailer.Error) {
+	err := mail.Validate()
+	if err != nil {
+		logger.WarnCtx(ctx, err.Error())
+		return nil, &mmailer.Error{Status: http.StatusUnprocessableEntity, Message: "invalid email", Fields: fieldErrors(err)}
+	}
+
+	if len(preferredService) > 0 {
+		ctx = logger.AddToLogContext(ctx, "preferred_service", preferredService)
+	}
+	// The facade is loaded once, so a reload during the request can't mix the services of two configs
+	f, err := authorizeSend(ctx, facade(), mail, preferredService)
+	if err != nil {
+		logger.WarnCtx(ctx, err.Error())
+		return nil, &mmailer.Error{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	mail, merr := resolveAttachments(ctx, mail)
+	if merr != nil {
+		return nil, merr
+	}
+
+	policy, err := admitTenant(ctx, mail)
+	if policy != nil {
+		ctx = logger.AddToLogContext(ctx, "tenant", policy.Id)
+	}
+	if err != nil {
+		logger.WarnCtx(ctx, err.Error())
+		return nil, &mmailer.Error{Status: tenantStatus(err), Message: err.Error()}
+	}
+	if policy != nil {
+		mail = policy.Apply(mail)
+		if preferredService == "" {
+			preferredService = policy.PreferredService(serviceNames(f))
+		}
+	}
+
+	if len(strings.TrimSpace(config.Get().FromDomainOverride)) > 0 {
+		parts := strings.Split(mail.From.Email, "@")
+		if len(parts) != 2 {
+			err = fmt.Errorf("couldn't parse from-adress: %s", mail.From.Email)
+			settleTenant(ctx, policy, mail, err)
+			logger.WarnCtx(ctx, err.Error())
+			return nil, &mmailer.Error{Status: http.StatusBadRequest, Message: "couldn't parse from-adress"}
+		}
+		parts[1] = strings.TrimSpace(config.Get().FromDomainOverride)
+		mail.From.Email = strings.Join(parts, "@")
+	}
+
+	res, err := sendByClass(ctx, f, mail, preferredService)
+	settleTenant(ctx, policy, mail, err)
+	var perr *mmailer.PartialError
+	if errors.As(err, &perr) {
+		// Sent to some of the recipients, who must not get it again, so it is not an error of the request
+		logger.ErrorCtx(ctx, err, "could not send email to every recipient")
+		res = append(perr.Sent, slicez.Map(perr.Failed, func(a mmailer.Address) mmailer.Response {
+			return mmailer.Response{Email: a.Email, Error: "could not send email"}
+		})...)
+		err = nil
+	}
+	if errors.Is(err, priority.ErrQueueFull) {
+		logger.WarnCtx(ctx, err.Error())
+		return nil, &mmailer.Error{Status: http.StatusServiceUnavailable, Message: err.Error()}
+	}
+	if errors.Is(err, mmailer.ErrTooLarge) {
+		logger.WarnCtx(ctx, err.Error())
+		return nil, &mmailer.Error{Status: http.StatusRequestEntityTooLarge, Message: err.Error()}
+	}
+	if err != nil {
+		logger.ErrorCtx(ctx, err, "could not send email")
+		return nil, &mmailer.Error{Status: http.StatusInternalServerError, Message: "could not send email"}
+	}
+	return res, nil
+}
+
+// sendError responds with an mmailer.Error, which mmailer.Client decodes
+func sendError(c echo.Context, status int, msg string, fields ...mmailer.FieldError) error {
+	return c.JSON(status, &mmailer.Error{Message: msg, Fields: fields})
+}
+
+func fieldErrors(err error) []mmailer.FieldError {
+	var verr *mmailer.ValidationError
+	if errors.As(err, &verr) {
+		return verr.Fields
+	}
+	return nil
+}
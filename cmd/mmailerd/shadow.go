@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/svc"
+)
+
+// shadowServices takes the SHADOW_SERVICE out of the services used for delivery, and has the others
+// mirror a share of what they send to it. The shadow service is returned, if any, to unmarshal its posthooks.
+// Without SHADOW_SEEDS only the sandbox and local services may be shadows, others would send a second copy
+// of the emails to the real recipients.
+func shadowServices(cfg *config.AppConfig, services []mmailer.Service, weights []uint) ([]mmailer.Service, []uint, mmailer.Service) {
+	if cfg.ShadowService == "" {
+		return services, weights, nil
+	}
+	i := slices.IndexFunc(services, func(s mmailer.Service) bool {
+		return strings.EqualFold(s.Name(), cfg.ShadowService)
+	})
+	if i < 0 {
+		logger.Warn(fmt.Sprintf("Shadow: disabled, %s is not among the services", cfg.ShadowService))
+		return services, weights, nil
+	}
+	if len(services) == 1 {
+		logger.Warn(fmt.Sprintf("Shadow: disabled, %s is the only service", cfg.ShadowService))
+		return services, weights, nil
+	}
+
+	shadow := services[i]
+	if len(cfg.ShadowSeeds) == 0 && !slicez.Contains([]string{"sandbox", "local"}, shadow.Name()) {
+		logger.Warn(fmt.Sprintf("Shadow: disabled, %s would send the mirrored emails to the real recipients, set SHADOW_SEEDS", shadow.Name()))
+		return services, weights, nil
+	}
+	services = slices.Delete(slices.Clone(services), i, i+1)
+	weights = slices.Delete(slices.Clone(weights), i, i+1)
+
+	opts := svc.ShadowOptions{
+		Percent: cfg.ShadowPercent,
+		Seeds: slicez.Map(cfg.ShadowSeeds, func(a string) mmailer.Address {
+			return mmailer.Address{Email: strings.TrimSpace(a)}
+		}),
+		Timeout: cfg.ShadowTimeout,
+	}
+	to := "the real recipients"
+	if len(opts.Seeds) > 0 {
+		to = strings.Join(cfg.ShadowSeeds, ", ")
+	}
+	logger.Info(fmt.Sprintf("Shadow: mirroring %.1f%% of the emails to %s, sent to %s", opts.Percent, shadow.Name(), to))
+	for i := range services {
+		services[i] = svc.WithShadow(services[i], shadow, opts)
+	}
+	return services, weights, shadow
+}
+
+// markShadow marks the posthooks of the shadow service of f, so they aren't taken for events of the emails sent
+func markShadow(f *mmailer.Facade, hooks []mmailer.Posthook) {
+	for i, h := range hooks {
+		hooks[i].Shadow = slicez.ContainsBy(f.PosthookServices, func(s mmailer.Service) bool {
+			return strings.EqualFold(s.Name(), h.Service)
+		})
+	}
+}
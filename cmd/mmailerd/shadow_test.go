@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/forward"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/modfin/mmailer/internal/stream"
+	"github.com/modfin/mmailer/services/sendgrid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink keeps the posthooks forwarded to it
+type recordingSink struct {
+	mu    sync.Mutex
+	hooks []mmailer.Posthook
+}
+
+func (r *recordingSink) Name() string {
+	return "recording"
+}
+
+func (r *recordingSink) Write(_ context.Context, hooks []mmailer.Posthook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hooks...)
+	return nil
+}
+
+func (r *recordingSink) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.hooks)
+}
+
+func TestShadowPosthook(t *testing.T) {
+	sandboxBox, localBox = mailbox.New(10), mailbox.New(10)
+	cfg := &config.AppConfig{
+		PosthookKey:   "hook",
+		ShadowService: "sendgrid",
+		ShadowSeeds:   []string{"seed@example.com"},
+		Providers: []config.Provider{
+			{Type: "local", Source: "test"},
+			{Type: "sendgrid", APIKey: "SG.test", Source: "test"},
+		},
+	}
+	f, err := buildFacade(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"local"}, serviceNames(f), "the shadow service is not selected for sending")
+	config.Set(cfg)
+	facades.Store(f)
+
+	hooks, unsubscribe := events.Subscribe(stream.Filter{}, 1)
+	defer unsubscribe()
+
+	body := `[{"email":"a@example.com","timestamp":1513299569,"event":"delivered","sg_event_id":"1","sg_message_id":"m1.filter"}]`
+	req := httptest.NewRequest(http.MethodPost, "/posthook?key=hook&service=sendgrid", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	require.NoError(t, posthook(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	select {
+	case e := <-hooks:
+		require.NotNil(t, e.Posthook)
+		assert.Equal(t, "a@example.com", e.Posthook.Email)
+		assert.True(t, e.Posthook.Shadow)
+	case <-time.After(time.Second):
+		t.Fatal("the posthook of the shadow service was not received")
+	}
+}
+
+func TestShadowPosthook_NotForwarded(t *testing.T) {
+	shadow := &config.AppConfig{}
+	f := mmailer.New(nil, nil)
+	f.PosthookServices = []mmailer.Service{sendgrid.New(nil)}
+	sink := &recordingSink{}
+	fw, err := forward.New(forward.Options{}, forward.Target{Sink: sink})
+	require.NoError(t, err)
+	fw.Start()
+	forwarder = fw
+	defer func() {
+		forwarder = nil
+		fw.Stop()
+	}()
+
+	hooks := []mmailer.Posthook{{Service: "sendgrid", Email: "a@example.com", Event: mmailer.EventBounce}}
+	markShadow(f, hooks)
+	config.Set(shadow)
+	require.NoError(t, processPosthooks(hooks))
+	assert.Never(t, func() bool { return sink.count() > 0 }, 100*time.Millisecond, 10*time.Millisecond)
+
+	shadow.ShadowPosthooks = true
+	require.NoError(t, processPosthooks(hooks))
+	assert.Eventually(t, func() bool { return sink.count() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestShadowServices_RequiresSeeds(t *testing.T) {
+	sandboxBox, localBox = mailbox.New(10), mailbox.New(10)
+	cfg := &config.AppConfig{
+		ShadowService: "sendgrid",
+		Providers: []config.Provider{
+			{Type: "local", Source: "test"},
+			{Type: "sendgrid", APIKey: "SG.test", Source: "test"},
+		},
+	}
+	f, err := buildFacade(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"local", "sendgrid"}, serviceNames(f), "without seeds a vendor can't be a shadow")
+	assert.Empty(t, f.PosthookServices)
+
+	cfg.ShadowService = "local"
+	cfg.Providers[0], cfg.Providers[1] = cfg.Providers[1], cfg.Providers[0]
+	f, err = buildFacade(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sendgrid"}, serviceNames(f), "the local service never delivers, so it needs no seeds")
+}
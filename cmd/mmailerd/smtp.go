@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/auth"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/smtpd"
+	"github.com/modfin/mmailer/internal/svc"
+)
+
+// startSMTP starts the smtp submission server if SMTP_IFACE is set, and returns nil otherwise
+func startSMTP() *smtpd.Server {
+	cfg := config.Get()
+	if cfg.SmtpInterface == "" {
+		return nil
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.SmtpTLSCert != "" || cfg.SmtpTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.SmtpTLSCert, cfg.SmtpTLSKey)
+		if err != nil {
+			logger.Error(err, "could not load smtp tls certificate")
+			os.Exit(1)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if tlsConfig == nil && !cfg.SmtpAllowInsecureAuth {
+		logger.Warn("smtp server has no SMTP_TLS_CERT and SMTP_ALLOW_INSECURE_AUTH is not set, no client will be able to authenticate")
+	}
+
+	s := smtpd.New(smtpd.Options{
+		Addr:              cfg.SmtpInterface,
+		Domain:            cfg.SmtpDomain,
+		TLS:               tlsConfig,
+		AllowInsecureAuth: cfg.SmtpAllowInsecureAuth,
+		MaxMessageBytes:   cfg.SmtpMaxMessageBytes,
+		MaxRecipients:     cfg.SmtpMaxRecipients,
+		Timeout:           cfg.SmtpTimeout,
+	}, func() auth.Keys {
+		return currentKeys()
+	}, smtpSend)
+
+	go func() {
+		logger.Info(fmt.Sprintf("Starting smtp server on %s, starttls: %v", cfg.SmtpInterface, tlsConfig != nil))
+		if err := s.ListenAndServe(); err != nil {
+			logger.Error(err, "Could not start smtp server, shutting down...")
+			os.Exit(1)
+		}
+	}()
+	return s
+}
+
+// smtpSend sends a submitted message like /send would. The X-Service and X-Dry-Run headers of
+// the message work like the http headers, and are not passed on.
+func smtpSend(ctx context.Context, email mmailer.Email) error {
+	logger.InfoCtx(ctx, "Received smtp message")
+	preferredService := email.Headers["X-Service"]
+	delete(email.Headers, "X-Service")
+	if dry, _ := strconv.ParseBool(email.Headers["X-Dry-Run"]); dry {
+		ctx = svc.DryRun(ctx)
+		ctx = logger.AddToLogContext(ctx, "dry_run", true)
+	}
+	delete(email.Headers, "X-Dry-Run")
+
+	_, merr := deliver(ctx, email, preferredService)
+	if merr != nil {
+		return merr
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/auth"
+	"github.com/modfin/mmailer/internal/config"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/svc"
+	"github.com/modfin/mmailer/internal/tenant"
+)
+
+var tenants atomic.Pointer[tenant.Tenants]
+var usage = tenant.NewUsage()
+
+func loadTenants() {
+	path := config.Get().TenantsFile
+	if path == "" {
+		logger.Info("Tenants: none, no TENANTS_FILE configured")
+		return
+	}
+	err := reloadTenants(path)
+	if err != nil {
+		logger.Error(err, "could not load tenants")
+		os.Exit(1)
+	}
+	watchFile(path, func() {
+		err := reloadTenants(path)
+		if err != nil {
+			logger.Error(err, "could not reload tenants, keeping the current ones")
+		}
+	})
+}
+
+func reloadTenants(path string) error {
+	t, err := tenant.Load(path)
+	if err != nil {
+		return err
+	}
+	tenants.Store(t)
+	logger.Info(fmt.Sprintf("Tenants loaded from %s, %d tenant(s)", path, len(t.Tenants)))
+	return nil
+}
+
+// admitTenant checks the email against the policy of the tenant owning the api key of the request,
+// and reserves its recipients in the quotas. The policy is nil if the key has no tenant.
+func admitTenant(ctx context.Context, mail mmailer.Email) (*tenant.Policy, error) {
+	t := tenants.Load()
+	key, ok := auth.FromContext(ctx)
+	if t == nil || !ok {
+		return nil, nil
+	}
+	policy, ok := t.ByKey(key.Name)
+	if !ok {
+		return nil, nil
+	}
+	err := policy.Check(mail)
+	if err == nil {
+		err = usage.Reserve(policy, tenant.Recipients(mail))
+	}
+	if err != nil {
+		tenant.Rejected(policy.Id, tenantReason(err))
+		return policy, err
+	}
+	return policy, nil
+}
+
+// settleTenant counts the email as sent, or gives back its reservation if it failed or was a dry run.
+// If it was only sent to some recipients, the reservation of the ones that failed is given back.
+func settleTenant(ctx context.Context, policy *tenant.Policy, mail mmailer.Email, err error) {
+	if policy == nil {
+		return
+	}
+	recipients := tenant.Recipients(mail)
+	var perr *mmailer.PartialError
+	if errors.As(err, &perr) && !svc.IsDryRun(ctx) {
+		usage.Release(policy, len(perr.Failed))
+		tenant.Sent(policy.Id, recipients-len(perr.Failed))
+		return
+	}
+	if err != nil || svc.IsDryRun(ctx) {
+		usage.Release(policy, recipients)
+		return
+	}
+	tenant.Sent(policy.Id, recipients)
+}
+
+func serviceNames(f *mmailer.Facade) []string {
+	return slicez.Map(f.Services, func(s mmailer.Service) string {
+		return s.Name()
+	})
+}
+
+func tenantReason(err error) string {
+	switch {
+	case errors.Is(err, tenant.ErrFromNotAllowed):
+		return "from"
+	case errors.Is(err, tenant.ErrTooManyRecipients):
+		return "recipients"
+	case errors.Is(err, tenant.ErrAttachmentTooLarge):
+		return "attachment_size"
+	case errors.Is(err, tenant.ErrQuotaExceeded):
+		return "quota"
+	default:
+		return "other"
+	}
+}
+
+func tenantStatus(err error) int {
+	switch {
+	case errors.Is(err, tenant.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, tenant.ErrAttachmentTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, tenant.ErrTooManyRecipients):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusForbidden
+	}
+}
+
+func tenantUsage(c echo.Context) error {
+	t := tenants.Load()
+	if t == nil {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	policy, ok := t.Get(c.Param("id"))
+	if !ok {
+		return c.String(http.StatusNotFound, "not found")
+	}
+	return c.JSON(http.StatusOK, usage.Report(policy))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettleTenant_PartiallySent(t *testing.T) {
+	policy := &tenant.Policy{Id: "partial", DailyQuota: 10}
+	mail := mmailer.Email{
+		To:         []mmailer.Address{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}},
+		Cc:         []mmailer.Address{{Email: "cc@example.com"}},
+		Individual: true,
+	}
+	require.NoError(t, usage.Reserve(policy, tenant.Recipients(mail)))
+
+	settleTenant(context.Background(), policy, mail, &mmailer.PartialError{
+		Sent:   []mmailer.Response{{Email: "a@example.com"}},
+		Failed: mail.To[1:],
+		Err:    errors.New("vendor is down"),
+	})
+	assert.Equal(t, 2, usage.Report(policy).Day.Used, "only a and cc were sent to")
+}
@@ -0,0 +1,246 @@
+package attach
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var fetched = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "attachment",
+	Name:      "fetch_count",
+	Help:      "The total number of attachments fetched, by source and status",
+}, []string{"source", "status"})
+
+var (
+	ErrTooLarge       = errors.New("attachment is too large")
+	ErrHostNotAllowed = errors.New("host is not allowed")
+	ErrNoObjectStore  = errors.New("no object storage is configured")
+)
+
+type Options struct {
+	// Hosts that url attachments may be fetched from, an entry starting with a dot matches any subdomain
+	Hosts []string
+	// MaxBytes is the max size of each fetched attachment
+	MaxBytes int64
+	// Timeout of fetching one attachment
+	Timeout time.Duration
+	// S3 is the object storage of object key attachments, they are rejected if nil
+	S3 *S3
+}
+
+// Error is the failure to fetch one of the attachments of an email
+type Error struct {
+	Index int
+	// Field is the json field of the reference, url or object_key
+	Field string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("attachments[%d].%s: %v", e.Index, e.Field, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Fetcher resolves attachments that reference a url or an object key into inline content
+type Fetcher struct {
+	opts   Options
+	client *http.Client
+}
+
+func New(opts Options) *Fetcher {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = mmailer.MaxAttachmentsSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	f := &Fetcher{opts: opts}
+	f.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !f.allowed(req.URL) {
+				return fmt.Errorf("redirect to %s: %w", req.URL.Hostname(), ErrHostNotAllowed)
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// Resolve fetches the attachments of the email that are references, and returns the email with their
+// content inline. The total size of the attachments is limited to mmailer.MaxAttachmentsSize.
+func (f *Fetcher) Resolve(ctx context.Context, email mmailer.Email) (mmailer.Email, error) {
+	if !References(email) {
+		return email, nil
+	}
+
+	var budget int64 = mmailer.MaxAttachmentsSize
+	for _, a := range email.Attachments {
+		budget -= int64(base64.StdEncoding.DecodedLen(len(a.Content)))
+	}
+
+	attachments := make([]mmailer.Attachment, len(email.Attachments))
+	copy(attachments, email.Attachments)
+	for i, a := range attachments {
+		var source, field string
+		var req *http.Request
+		var err error
+		switch {
+		case a.URL != "":
+			source, field = "url", "url"
+			req, err = f.urlRequest(ctx, a.URL)
+		case a.ObjectKey != "":
+			source, field = "s3", "object_key"
+			req, err = f.objectRequest(ctx, a.ObjectKey)
+		default:
+			continue
+		}
+		if err == nil {
+			a, err = f.fetch(req, a, min(f.opts.MaxBytes, budget))
+		}
+		if err != nil {
+			fetched.WithLabelValues(source, "error").Inc()
+			return email, &Error{Index: i, Field: field, Err: err}
+		}
+		fetched.WithLabelValues(source, "ok").Inc()
+		budget -= int64(base64.StdEncoding.DecodedLen(len(a.Content)))
+		attachments[i] = a
+	}
+	email.Attachments = attachments
+	return email, nil
+}
+
+// References is true if any of the attachments of the email needs to be fetched
+func References(email mmailer.Email) bool {
+	for _, a := range email.Attachments {
+		if a.URL != "" || a.ObjectKey != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fetcher) allowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, h := range f.opts.Hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fetcher) urlRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("scheme %s is not supported", u.Scheme)
+	}
+	if !f.allowed(u) {
+		return nil, fmt.Errorf("%s: %w", u.Hostname(), ErrHostNotAllowed)
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+}
+
+func (f *Fetcher) objectRequest(ctx context.Context, key string) (*http.Request, error) {
+	if f.opts.S3 == nil {
+		return nil, ErrNoObjectStore
+	}
+	return f.opts.S3.request(ctx, key, time.Now())
+}
+
+func (f *Fetcher) fetch(req *http.Request, a mmailer.Attachment, limit int64) (mmailer.Attachment, error) {
+	if limit <= 0 {
+		return a, ErrTooLarge
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), f.opts.Timeout)
+	defer cancel()
+
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return a, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return a, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return a, ErrTooLarge
+	}
+
+	content, head, err := Encode(resp.Body, limit)
+	if err != nil {
+		return a, err
+	}
+	if a.Name == "" {
+		a.Name = path.Base(req.URL.Path)
+	}
+	if a.ContentType == "" {
+		a.ContentType = ContentType(resp.Header.Get("Content-Type"), head)
+	}
+	a.Content = content
+	a.URL, a.ObjectKey = "", ""
+	return a, nil
+}
+
+// Encode reads r, of at most limit bytes, into base64. It returns the first bytes read as well, for ContentType.
+func Encode(r io.Reader, limit int64) (content string, head []byte, err error) {
+	var b strings.Builder
+	enc := base64.NewEncoder(base64.StdEncoding, &b)
+	sniff := &sniffer{}
+	n, err := io.Copy(io.MultiWriter(enc, sniff), io.LimitReader(r, limit+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if n > limit {
+		return "", nil, ErrTooLarge
+	}
+	err = enc.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	return b.String(), sniff.head, nil
+}
+
+// ContentType is the declared media type, unless it is missing or generic, in which case it is sniffed from head
+func ContentType(declared string, head []byte) string {
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err == nil && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return declared
+	}
+	return http.DetectContentType(head)
+}
+
+// sniffer keeps the first 512 bytes written, which is what http.DetectContentType considers
+type sniffer struct {
+	head []byte
+}
+
+func (s *sniffer) Write(p []byte) (int, error) {
+	if n := 512 - len(s.head); n > 0 {
+		s.head = append(s.head, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+	"golang.org/x/time/rate"
+)
+
+// Key is an api key allowed to send email, optionally restricted in what it may send and how often
+type Key struct {
+	Name   string
+	Secret string
+	// FromDomains the key may send from, any if empty
+	FromDomains []string
+	// Services the key may send through, any if empty
+	Services []string
+
+	limiter *rate.Limiter
+}
+
+// ParseKey parses a key of the legacy format name:secret followed by optional colon separated properties, eg.
+//
+//	billing:s3cret:from=example.com,example.org:services=sendgrid,mailjet:rate=10/s:burst=20
+//
+//	from      comma separated domains the key may send from
+//	services  comma separated services the key may send through
+//	rate      max number of requests per second, minute or hour, eg. 10/s, 600/m or 1000/h
+//	burst     max number of requests at once, defaults to the number in rate
+//
+// Secrets with colons can't be given in this format, see NewKey.
+func ParseKey(s string) (*Key, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" || parts[1] == "" {
+		return nil, fmt.Errorf("name and secret are required, expected name:secret[:key=value...]")
+	}
+
+	var from, services []string
+	var limit string
+	var burst int
+	for _, p := range parts[2:] {
+		ps := strings.SplitN(p, "=", 2)
+		if len(ps) != 2 || strings.TrimSpace(ps[0]) == "" || strings.TrimSpace(ps[1]) == "" {
+			return nil, fmt.Errorf("each property has to be of the format 'key=value': got '%s', secrets with colons have to be given as yaml", p)
+		}
+		value := strings.TrimSpace(ps[1])
+		switch strings.ToLower(strings.TrimSpace(ps[0])) {
+		case "from":
+			from = strings.Split(value, ",")
+		case "services":
+			services = strings.Split(value, ",")
+		case "rate":
+			limit = value
+		case "burst":
+			b, err := strconv.Atoi(value)
+			if err != nil || b < 1 {
+				return nil, fmt.Errorf("burst has to be a positive number, got '%s'", value)
+			}
+			burst = b
+		default:
+			return nil, fmt.Errorf("unknown property '%s'", ps[0])
+		}
+	}
+	return NewKey(parts[0], parts[1], from, services, limit, burst)
+}
+
+// NewKey creates a key that may send from the domains in from and through services, any if empty. The limit is the
+// max number of requests per second, minute or hour, eg. 10/s, 600/m or 1000/h, and burst at once, which defaults to
+// the number in limit. An empty limit is unlimited.
+func NewKey(name, secret string, from, services []string, limit string, burst int) (*Key, error) {
+	k := &Key{
+		Name:        strings.TrimSpace(name),
+		Secret:      secret,
+		FromDomains: splitList(strings.Join(from, ",")),
+		Services:    splitList(strings.Join(services, ",")),
+	}
+	if k.Name == "" || k.Secret == "" {
+		return nil, fmt.Errorf("name and secret are required")
+	}
+	if burst < 0 {
+		return nil, fmt.Errorf("burst has to be a positive number, got %d", burst)
+	}
+	if limit == "" {
+		return k, nil
+	}
+	r, count, err := parseRate(limit)
+	if err != nil {
+		return nil, err
+	}
+	if burst == 0 {
+		burst = count
+	}
+	k.limiter = rate.NewLimiter(r, burst)
+	return k, nil
+}
+
+func parseRate(s string) (rate.Limit, int, error) {
+	n, per, ok := strings.Cut(s, "/")
+	count, err := strconv.Atoi(n)
+	if !ok || err != nil || count < 1 {
+		return 0, 0, fmt.Errorf("rate has to be of the format <number>/<s|m|h>, got '%s'", s)
+	}
+	var d time.Duration
+	switch per {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		return 0, 0, fmt.Errorf("rate has to be of the format <number>/<s|m|h>, got '%s'", s)
+	}
+	return rate.Limit(float64(count) / d.Seconds()), count, nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// AllowFrom reports if the key may send from address
+func (k *Key) AllowFrom(address string) bool {
+	if len(k.FromDomains) == 0 {
+		return true
+	}
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return false
+	}
+	_, domain, _ := strings.Cut(a.Address, "@")
+	return slicez.Contains(k.FromDomains, strings.ToLower(domain))
+}
+
+// AllowService reports if the key may send through the service
+func (k *Key) AllowService(name string) bool {
+	return len(k.Services) == 0 || slicez.Contains(k.Services, strings.ToLower(name))
+}
+
+// Allow reports if the key is within its rate limit, and if so counts the request
+func (k *Key) Allow() bool {
+	return k.limiter == nil || k.limiter.Allow()
+}
+
+type Keys []*Key
+
+// NewKeys validates that names and secrets are unique
+func NewKeys(keys ...*Key) (Keys, error) {
+	names := map[string]bool{}
+	secrets := map[string]bool{}
+	for _, k := range keys {
+		if names[k.Name] {
+			return nil, fmt.Errorf("api key name %q is not unique", k.Name)
+		}
+		if secrets[k.Secret] {
+			return nil, fmt.Errorf("api key %q has the same secret as another key", k.Name)
+		}
+		names[k.Name] = true
+		secrets[k.Secret] = true
+	}
+	return keys, nil
+}
+
+// KeepLimiters makes the keys take over the rate limiters of the old keys with the same name and rate limit,
+// so that reloading the keys doesn't reset how many requests they have left
+func (ks Keys) KeepLimiters(old Keys) {
+	for _, k := range ks {
+		for _, o := range old {
+			if k.Name != o.Name || k.limiter == nil || o.limiter == nil {
+				continue
+			}
+			if k.limiter.Limit() == o.limiter.Limit() && k.limiter.Burst() == o.limiter.Burst() {
+				k.limiter = o.limiter
+			}
+		}
+	}
+}
+
+// Lookup finds the key with the secret. Every key is compared, in constant time, so the
+// time it takes doesn't give away which keys exist
+func (ks Keys) Lookup(secret string) (*Key, bool) {
+	var found *Key
+	for _, k := range ks {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(k.Secret)) == 1 {
+			found = k
+		}
+	}
+	return found, found != nil && secret != ""
+}
+
+// FromRequest returns the api key of a request, from the Authorization: Bearer header, or the
+// legacy key query param
+func FromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, _ := strings.Cut(h, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("key")
+}
+
+type ctxKey struct{}
+
+func WithKey(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, ctxKey{}, k)
+}
+
+// FromContext returns the key the request was authenticated with
+func FromContext(ctx context.Context) (*Key, bool) {
+	k, ok := ctx.Value(ctxKey{}).(*Key)
+	return k, ok
+}
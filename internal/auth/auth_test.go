@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKey(t *testing.T) {
+	k, err := ParseKey("billing:s3cret:from=Example.com, example.org:services=sendgrid,mailjet:rate=2/m:burst=1")
+	require.NoError(t, err)
+	assert.Equal(t, "billing", k.Name)
+	assert.Equal(t, "s3cret", k.Secret)
+	assert.Equal(t, []string{"example.com", "example.org"}, k.FromDomains)
+	assert.Equal(t, []string{"sendgrid", "mailjet"}, k.Services)
+
+	assert.True(t, k.AllowFrom("Billing <billing@EXAMPLE.com>"))
+	assert.False(t, k.AllowFrom("billing@example.net"))
+	assert.False(t, k.AllowFrom("not an address"))
+	assert.True(t, k.AllowService("SendGrid"))
+	assert.False(t, k.AllowService("mandrill"))
+
+	assert.True(t, k.Allow())
+	assert.False(t, k.Allow(), "burst of one")
+
+	k, err = ParseKey("open:s3cret")
+	require.NoError(t, err)
+	assert.True(t, k.AllowFrom("a@anything.com"))
+	assert.True(t, k.AllowService("mandrill"))
+	for range 100 {
+		assert.True(t, k.Allow())
+	}
+
+	for _, bad := range []string{"", "name", "name:", ":secret", "n:s:rate=10", "n:s:rate=10/d", "n:s:burst=0", "n:s:color=red", "n:s:from"} {
+		_, err = ParseKey(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	k, err := NewKey("billing", "s3:cr:et", []string{"Example.com"}, nil, "1/s", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "s3:cr:et", k.Secret, "secrets may have colons")
+	assert.Equal(t, []string{"example.com"}, k.FromDomains)
+	assert.True(t, k.AllowService("mandrill"))
+	assert.True(t, k.Allow())
+	assert.False(t, k.Allow(), "burst defaults to the number in the rate")
+
+	_, err = ParseKey("billing:s3:cr:et")
+	assert.ErrorContains(t, err, "secrets with colons have to be given as yaml")
+	_, err = NewKey("", "s3cret", nil, nil, "", 0)
+	assert.Error(t, err)
+	_, err = NewKey("billing", "", nil, nil, "", 0)
+	assert.Error(t, err)
+	_, err = NewKey("billing", "s3cret", nil, nil, "10", 0)
+	assert.Error(t, err)
+}
+
+func TestKeys(t *testing.T) {
+	a, _ := ParseKey("a:one")
+	b, _ := ParseKey("b:two")
+	keys, err := NewKeys(a, b)
+	require.NoError(t, err)
+
+	k, ok := keys.Lookup("two")
+	assert.True(t, ok)
+	assert.Equal(t, "b", k.Name)
+	_, ok = keys.Lookup("three")
+	assert.False(t, ok)
+	_, ok = keys.Lookup("")
+	assert.False(t, ok)
+
+	_, err = NewKeys(a, a)
+	assert.Error(t, err)
+	c, _ := ParseKey("c:one")
+	_, err = NewKeys(a, c)
+	assert.Error(t, err)
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/send?key=legacy", nil)
+	assert.Equal(t, "legacy", FromRequest(r))
+
+	r.Header.Set("Authorization", "Bearer s3cret")
+	assert.Equal(t, "s3cret", FromRequest(r))
+
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	assert.Equal(t, "", FromRequest(r))
+}
+
+func TestKeys_KeepLimiters(t *testing.T) {
+	parse := func(rows ...string) Keys {
+		var keys Keys
+		for _, r := range rows {
+			k, err := ParseKey(r)
+			require.NoError(t, err)
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	old := parse("a:one:rate=1/h", "b:two:rate=1/h", "c:three:rate=1/h")
+	for _, k := range old {
+		assert.True(t, k.Allow())
+	}
+
+	keys := parse("a:new:rate=1/h", "b:two:rate=2/h", "c:three:rate=1/h:burst=2")
+	keys.KeepLimiters(old)
+	assert.False(t, keys[0].Allow(), "the limit is unchanged, so the request left is already used")
+	assert.True(t, keys[1].Allow(), "the rate changed, so the limiter is new")
+	assert.True(t, keys[2].Allow(), "the burst changed, so the limiter is new")
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIKey is a named key of API_KEYS, given as a yaml, or json, list rather than as colon separated rows, eg. for
+// secrets with colons
+type APIKey struct {
+	Name string `yaml:"name" json:"name"`
+	// Secret may be a reference to a secret
+	Secret string `yaml:"secret" json:"secret"`
+	// From are the domains the key may send from, and Services what it may send through, any if empty
+	From     []string `yaml:"from,omitempty" json:"from,omitempty"`
+	Services []string `yaml:"services,omitempty" json:"services,omitempty"`
+	// Rate is the max number of requests per second, minute or hour, eg. 10/s, and Burst at once
+	Rate  string `yaml:"rate,omitempty" json:"rate,omitempty"`
+	Burst int    `yaml:"burst,omitempty" json:"burst,omitempty"`
+
+	// Source is where the key is configured, for errors, eg. config.yaml:12 or API_KEYS line 2
+	Source string `yaml:"-" json:"-"`
+}
+
+// isMapList tells if n is a list of maps, as API_KEYS given as yaml is, rather than of colon separated rows
+func isMapList(n *yaml.Node) bool {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	return n.Kind == yaml.SequenceNode && len(n.Content) > 0 && n.Content[0].Kind == yaml.MappingNode
+}
+
+// decodeAPIKeys decodes a yaml, or json, list of api keys. At returns where a line of it is, for errors.
+func decodeAPIKeys(n *yaml.Node, at func(line int) string) ([]APIKey, []error) {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	var keys []APIKey
+	var errs []error
+	for i, item := range n.Content {
+		source := at(item.Line)
+		if item.Kind != yaml.MappingNode {
+			errs = append(errs, fmt.Errorf("%s: api_keys[%d] must be a map", source, i))
+			continue
+		}
+		errs = append(errs, unknownFields(item, reflect.TypeOf(APIKey{}), fmt.Sprintf("api_keys[%d]", i), at)...)
+		var k APIKey
+		if err := item.Decode(&k); err != nil {
+			errs = append(errs, fmt.Errorf("%s: api_keys[%d]: %w", source, i, err))
+			continue
+		}
+		k.Source = source
+		keys = append(keys, k)
+	}
+	return keys, errs
+}
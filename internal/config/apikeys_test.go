@@ -0,0 +1,31 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_APIKeys(t *testing.T) {
+	t.Setenv("BILLING_KEY", "s3:cr:et")
+	t.Setenv("API_KEYS", "- name: billing\n  secret: env:BILLING_KEY\n  from: [example.com]\n  rate: 10/s\n- {name: open, secret: 'a:b'}")
+	c, err := Load()
+	require.NoError(t, err)
+	require.Len(t, c.Keys, 2)
+	assert.Equal(t, APIKey{Name: "billing", Secret: "s3:cr:et", From: []string{"example.com"}, Rate: "10/s", Source: "API_KEYS line 1"}, c.Keys[0])
+	assert.Equal(t, "a:b", c.Keys[1].Secret)
+	assert.Empty(t, c.APIKeys)
+	assert.Contains(t, c.secretValues(), "s3:cr:et")
+
+	t.Setenv("API_KEYS", "billing:s3cret\nopen:s3cret")
+	c, err = Load()
+	require.NoError(t, err)
+	assert.Empty(t, c.Keys)
+	assert.Equal(t, []string{"billing:s3cret", "open:s3cret"}, c.APIKeys, "the colon separated rows are kept")
+
+	t.Setenv("API_KEYS", "")
+	t.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", "API_KEYS:\n  - name: billing\n    secret: s3cret\n    domains: [example.com]\n"))
+	_, err = Load()
+	assert.ErrorContains(t, err, "config.yaml:4: api_keys[0]: unknown field domains")
+}
@@ -1,57 +1,376 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/modfin/henry/slicez"
 	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/secrets"
+	"gopkg.in/yaml.v3"
 )
 
 type AppConfig struct {
-	PublicURL   string `env:"PUBLIC_URL" envDefault:"http://example.com/path/to/mmailer"`
-	APIKey      string `env:"API_KEY"`
-	PosthookKey string `env:"POSTHOOK_KEY"`
-	Metrics     bool   `env:"METRICS" envDefault:"true"`
+	PublicURL   string   `env:"PUBLIC_URL" envDefault:"http://example.com/path/to/mmailer"`
+	APIKey      string   `env:"API_KEY"`
+	APIKeys     []string `env:"API_KEYS" envSeparator:"\n"`
+	PosthookKey string   `env:"POSTHOOK_KEY"`
+	Metrics     bool     `env:"METRICS" envDefault:"true"`
+	// Keys are API_KEYS if it is a yaml, or json, list of keys rather than colon separated rows, which are then
+	// not in APIKeys
+	Keys []APIKey
 
 	HttpInterface       string `env:"HTTP_IFACE" envDefault:":8081"`
 	PublicHttpInterface string `env:"PUBLIC_HTTP_IFACE" envDefault:":8080"`
 
 	FromDomainOverride string `env:"FROM_DOMAIN_OVERRIDE"`
 
+	// Providers are the services, from PROVIDERS, or else migrated from SERVICES and SERVICE_DOMAIN_API_KEYS
+	Providers []Provider
+	// ProvidersSpec is PROVIDERS, as yaml or json, in the environment
+	ProvidersSpec        string   `env:"PROVIDERS"`
 	Services             []string `env:"SERVICES" envSeparator:"\n"`
 	ServiceIpPoolConfig  []string `env:"SERVICE_IP_POOL_CONFIG" envSeparator:"\n"`
 	ServiceDomainApiKeys []string `env:"SERVICE_DOMAIN_API_KEYS" envSeparator:"\n"`
 
-	RetryStrategy  string `env:"RETRY_STRATEGY"`
-	SelectStrategy string `env:"SELECT_STRATEGY"`
+	ShadowService string        `env:"SHADOW_SERVICE"`
+	ShadowPercent float64       `env:"SHADOW_PERCENT" envDefault:"0"`
+	ShadowSeeds   []string      `env:"SHADOW_SEEDS" envSeparator:","`
+	ShadowTimeout time.Duration `env:"SHADOW_TIMEOUT" envDefault:"1m"`
+	// ShadowPosthooks forwards the posthooks of the shadow service, marked with shadow, rather than dropping them
+	ShadowPosthooks bool `env:"SHADOW_POSTHOOKS" envDefault:"false"`
+
+	RoutingRules string `env:"ROUTING_RULES"`
+
+	RetryStrategy  string        `env:"RETRY_STRATEGY"`
+	SelectStrategy string        `env:"SELECT_STRATEGY"`
+	HedgeThreshold time.Duration `env:"HEDGE_THRESHOLD" envDefault:"1s"`
+
+	AdaptiveMinShare      float64       `env:"ADAPTIVE_MIN_SHARE" envDefault:"0.05"`
+	AdaptiveMaxShare      float64       `env:"ADAPTIVE_MAX_SHARE" envDefault:"1"`
+	AdaptiveLatencyTarget time.Duration `env:"ADAPTIVE_LATENCY_TARGET" envDefault:"2s"`
+	AdaptiveAlpha         float64       `env:"ADAPTIVE_ALPHA" envDefault:"0.05"`
+
+	PosthookForward     []string      `env:"POSTHOOK_FORWARD" envSeparator:"\n"`
+	PosthookSinks       []string      `env:"POSTHOOK_SINKS" envSeparator:"\n"`
+	PosthookSecret      string        `env:"POSTHOOK_FORWARD_SECRET"`
+	PosthookSpoolDir    string        `env:"POSTHOOK_SPOOL_DIR"`
+	PosthookBatchSize   int           `env:"POSTHOOK_BATCH_SIZE" envDefault:"100"`
+	PosthookBatchWait   time.Duration `env:"POSTHOOK_BATCH_WAIT" envDefault:"1s"`
+	PosthookMaxAttempts int           `env:"POSTHOOK_MAX_ATTEMPTS" envDefault:"12"`
+	PosthookMaxBackoff  time.Duration `env:"POSTHOOK_MAX_BACKOFF" envDefault:"10m"`
+	PosthookTimeout     time.Duration `env:"POSTHOOK_FORWARD_TIMEOUT" envDefault:"10s"`
+
+	PosthookRoutes string `env:"POSTHOOK_ROUTES"`
+
+	PosthookDedup         bool          `env:"POSTHOOK_DEDUP" envDefault:"true"`
+	PosthookDedupTTL      time.Duration `env:"POSTHOOK_DEDUP_TTL" envDefault:"72h"`
+	PosthookDedupCapacity int           `env:"POSTHOOK_DEDUP_CAPACITY" envDefault:"1000000"`
+	PosthookDedupFile     string        `env:"POSTHOOK_DEDUP_FILE"`
+
+	TenantsFile string `env:"TENANTS_FILE"`
+
+	PriorityCriticalWorkers  int      `env:"PRIORITY_CRITICAL_WORKERS" envDefault:"16"`
+	PriorityCriticalQueue    int      `env:"PRIORITY_CRITICAL_QUEUE" envDefault:"256"`
+	PriorityCriticalServices []string `env:"PRIORITY_CRITICAL_SERVICES" envSeparator:","`
+	PriorityCriticalRetry    string   `env:"PRIORITY_CRITICAL_RETRY_STRATEGY"`
+	PriorityNormalWorkers    int      `env:"PRIORITY_NORMAL_WORKERS" envDefault:"32"`
+	PriorityNormalQueue      int      `env:"PRIORITY_NORMAL_QUEUE" envDefault:"1024"`
+	PriorityNormalServices   []string `env:"PRIORITY_NORMAL_SERVICES" envSeparator:","`
+	PriorityNormalRetry      string   `env:"PRIORITY_NORMAL_RETRY_STRATEGY"`
+	PriorityBulkWorkers      int      `env:"PRIORITY_BULK_WORKERS" envDefault:"8"`
+	PriorityBulkQueue        int      `env:"PRIORITY_BULK_QUEUE" envDefault:"4096"`
+	PriorityBulkServices     []string `env:"PRIORITY_BULK_SERVICES" envSeparator:","`
+	PriorityBulkRetry        string   `env:"PRIORITY_BULK_RETRY_STRATEGY"`
+
+	SmtpInterface         string        `env:"SMTP_IFACE"`
+	SmtpDomain            string        `env:"SMTP_DOMAIN" envDefault:"localhost"`
+	SmtpTLSCert           string        `env:"SMTP_TLS_CERT"`
+	SmtpTLSKey            string        `env:"SMTP_TLS_KEY"`
+	SmtpAllowInsecureAuth bool          `env:"SMTP_ALLOW_INSECURE_AUTH"`
+	SmtpMaxMessageBytes   int64         `env:"SMTP_MAX_MESSAGE_BYTES" envDefault:"67108864"`
+	SmtpMaxRecipients     int           `env:"SMTP_MAX_RECIPIENTS" envDefault:"100"`
+	SmtpTimeout           time.Duration `env:"SMTP_TIMEOUT" envDefault:"1m"`
+
+	AttachmentHosts        []string      `env:"ATTACHMENT_HOSTS" envSeparator:","`
+	AttachmentMaxBytes     int64         `env:"ATTACHMENT_MAX_BYTES" envDefault:"26214400"`
+	AttachmentFetchTimeout time.Duration `env:"ATTACHMENT_FETCH_TIMEOUT" envDefault:"30s"`
+
+	S3Endpoint        string `env:"S3_ENDPOINT"`
+	S3Region          string `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKeyId     string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+
+	SandboxCapacity    int `env:"SANDBOX_CAPACITY" envDefault:"1000"`
+	LocalInboxCapacity int `env:"LOCAL_INBOX_CAPACITY" envDefault:"1000"`
+
+	WatchInterval time.Duration `env:"WATCH_INTERVAL" envDefault:"10s"`
+
+	// ConfigFile is a yaml, json or toml file with config, by env name, that overrides the environment
+	ConfigFile string `env:"CONFIG_FILE"`
+
+	// VaultAddr and VaultToken are of the Vault compatible API that vault:// references are read from
+	VaultAddr      string        `env:"VAULT_ADDR"`
+	VaultToken     string        `env:"VAULT_TOKEN"`
+	SecretsRefresh time.Duration `env:"SECRETS_REFRESH" envDefault:"5m"`
+	// secrets resolved the references of the config
+	secrets *secrets.Resolver
 
-	PosthookForward string   `env:"POSTHOOK_FORWARD"`
 	Environment     string   `env:"ENVIRONMENT" envDefault:"DEVELOPMENT"`
 	AllowListFilter []string `env:"ALLOW_LIST" envSeparator:"," envDefault:"@modularfinance.se"`
 }
 
 var (
 	once sync.Once
-	cfg  AppConfig
+	cfg  atomic.Pointer[AppConfig]
 )
 
+// Get returns the current config, which is loaded on the first call
 func Get() *AppConfig {
 	once.Do(func() {
-		cfg = AppConfig{}
-		if err := env.Parse(&cfg); err != nil {
-			logger.Error(err, "Couldn't parse AppConfig from env")
+		c, err := Load()
+		if err != nil {
+			logger.Error(err, "Couldn't parse AppConfig")
 			panic(err)
 		}
+		cfg.Store(c)
 	})
-	return &cfg
+	return cfg.Load()
+}
+
+// Set replaces the current config, callers of Get keeps the config they got
+func Set(c *AppConfig) {
+	once.Do(func() {})
+	cfg.Store(c)
+}
+
+// Load parses the config from the environment and, if CONFIG_FILE is set, the file, whose values override the
+// environment. Values, list items and provider credentials that are references to secrets, file://, vault:// or env:,
+// are replaced by the secrets, which are then redacted from the logs.
+func Load() (*AppConfig, error) {
+	return load(true)
+}
+
+// LoadReferences parses the config like Load, but keeps the references to secrets as they are, eg. to print the
+// config without revealing them. The providers are not validated, since their credentials may be references.
+func LoadReferences() (*AppConfig, error) {
+	return load(false)
+}
+
+func load(resolve bool) (*AppConfig, error) {
+	environment := toMap(os.Environ())
+	var file *fileConfig
+	if path := environment["CONFIG_FILE"]; path != "" {
+		var err error
+		file, err = readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range file.values {
+			environment[k] = v
+		}
+	}
+
+	ctx := context.Background()
+	resolver := secrets.New(environment["VAULT_ADDR"], "")
+	var errs []error
+	if resolve {
+		token, err := resolver.Resolve(ctx, environment["VAULT_TOKEN"])
+		if err != nil {
+			return nil, fmt.Errorf("VAULT_TOKEN: %w", err)
+		}
+		resolver.VaultToken = token
+		if token != "" {
+			environment["VAULT_TOKEN"] = token
+		}
+		errs = resolveValues(ctx, resolver, environment)
+	}
+
+	c := &AppConfig{secrets: resolver}
+	if err := env.Parse(c, env.Options{Environment: environment}); err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+
+	var perrs []error
+	switch {
+	case file != nil && file.providers != nil:
+		c.Providers = file.providers
+	case c.ProvidersSpec != "":
+		var n yaml.Node
+		if err := yaml.Unmarshal([]byte(c.ProvidersSpec), &n); err != nil {
+			return nil, fmt.Errorf("could not parse PROVIDERS: %w", err)
+		}
+		c.Providers, perrs = decodeProviders(&n, func(line int) string { return fmt.Sprintf("PROVIDERS line %d", line) })
+	default:
+		c.Providers, perrs = LegacyProviders(c.Services, c.ServiceDomainApiKeys, strings.EqualFold(c.SelectStrategy, "weighted"))
+		if !resolve {
+			// a row that is a reference is only known as a whole, once resolved
+			for i, row := range c.Services {
+				if secrets.IsReference(row) {
+					perrs = append(perrs, fmt.Errorf("SERVICES row %d: is a reference to a secret, %s", i+1, row))
+				}
+			}
+		}
+	}
+	if c.ProvidersSpec != "" || (file != nil && file.providers != nil) {
+		if len(c.Services) > 0 || len(c.ServiceDomainApiKeys) > 0 {
+			logger.Warn("SERVICES and SERVICE_DOMAIN_API_KEYS are ignored, PROVIDERS is set")
+		}
+	}
+	errs = append(errs, perrs...)
+
+	var kerrs []error
+	switch {
+	case file != nil && file.keys != nil:
+		c.Keys = file.keys
+	case len(c.APIKeys) > 0:
+		var n yaml.Node
+		if yaml.Unmarshal([]byte(strings.Join(c.APIKeys, "\n")), &n) == nil && isMapList(&n) {
+			c.Keys, kerrs = decodeAPIKeys(&n, func(line int) string { return fmt.Sprintf("API_KEYS line %d", line) })
+		}
+	}
+	if c.Keys != nil {
+		c.APIKeys = nil
+	}
+	errs = append(errs, kerrs...)
+	if !resolve {
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	for i := range c.Providers {
+		p := &c.Providers[i]
+		for _, field := range p.secrets() {
+			value, err := resolver.Resolve(ctx, *field)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", p.Source, p.Type, err))
+				continue
+			}
+			*field = value
+		}
+	}
+	for i := range c.Keys {
+		k := &c.Keys[i]
+		value, err := resolver.Resolve(ctx, k.Secret)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", k.Source, k.Name, err))
+			continue
+		}
+		k.Secret = value
+	}
+	errs = append(errs, ValidateProviders(c.Providers))
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	logger.Redact(c.secretValues()...)
+	return c, nil
+}
+
+// resolveValues replaces the values of the environment that are references to secrets by the secrets. For list
+// values, each item that is a reference is replaced, unless the whole value is one.
+func resolveValues(ctx context.Context, resolver *secrets.Resolver, environment map[string]string) []error {
+	separators := envSeparators()
+	names := make([]string, 0, len(separators))
+	for name := range separators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lists := envLists()
+
+	var errs []error
+	for _, name := range names {
+		value, ok := environment[name]
+		if !ok || name == "VAULT_TOKEN" {
+			continue
+		}
+		items := []string{value}
+		if lists[name] && !secrets.IsReference(value) {
+			items = strings.Split(value, separators[name])
+		}
+		changed := false
+		for i, item := range items {
+			if !secrets.IsReference(item) {
+				continue
+			}
+			resolved, err := resolver.Resolve(ctx, item)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			items[i], changed = resolved, true
+		}
+		if changed {
+			environment[name] = strings.Join(items, separators[name])
+		}
+	}
+	return errs
+}
+
+// secretValues are the secrets of the config, which are redacted from the logs
+func (a *AppConfig) secretValues() []string {
+	values := append(a.secrets.Values(), a.APIKey, a.PosthookKey, a.PosthookSecret, a.S3SecretAccessKey, a.VaultToken)
+	for i := range a.Providers {
+		for _, field := range a.Providers[i].secrets() {
+			values = append(values, *field)
+		}
+	}
+	for _, k := range a.Keys {
+		values = append(values, k.Secret)
+	}
+	return values
+}
+
+// SecretsChanged resolves the references to secrets of the config again, and tells if any of them has changed
+func (a *AppConfig) SecretsChanged(ctx context.Context) (bool, error) {
+	if a.secrets == nil {
+		return false, nil
+	}
+	return a.secrets.Changed(ctx)
+}
+
+func toMap(environ []string) map[string]string {
+	m := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
 }
 
 func (a *AppConfig) IsDev() bool {
 	return a.Environment == "DEVELOPMENT"
 }
 
+// PriorityClass is the config of the emails of a priority
+type PriorityClass struct {
+	Workers  int
+	Queue    int
+	Services []string
+	// Retry is the name of the retry strategy, or empty for RETRY_STRATEGY
+	Retry string
+}
+
+func (a *AppConfig) GetPriorityClass(priority string) PriorityClass {
+	switch priority {
+	case "critical":
+		return PriorityClass{a.PriorityCriticalWorkers, a.PriorityCriticalQueue, a.PriorityCriticalServices, a.PriorityCriticalRetry}
+	case "bulk":
+		return PriorityClass{a.PriorityBulkWorkers, a.PriorityBulkQueue, a.PriorityBulkServices, a.PriorityBulkRetry}
+	default:
+		return PriorityClass{a.PriorityNormalWorkers, a.PriorityNormalQueue, a.PriorityNormalServices, a.PriorityNormalRetry}
+	}
+}
+
 func (a *AppConfig) GetServiceIpPoolConfig(service string) []string {
 	filteredPoolConfigs := slicez.Filter(a.ServiceIpPoolConfig, func(s string) bool {
 		return strings.HasPrefix(s, service)
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is what is read from a config file
+type fileConfig struct {
+	// values are the env values, by env name. Lists are joined by the separator of their field,
+	// eg. SERVICES by line breaks and ALLOW_LIST by commas.
+	values map[string]string
+	// providers are set if the file has PROVIDERS
+	providers []Provider
+	// keys are set if the file has API_KEYS as a list of maps
+	keys []APIKey
+}
+
+func readFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fileEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		// json is read as yaml, which it is a subset of, to know the lines
+		entries, err = yamlEntries(data, path)
+	case ".toml":
+		entries, err = tomlEntries(data, path)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml, .json or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	separators := envSeparators()
+	fc := &fileConfig{values: make(map[string]string, len(entries))}
+	var errs []error
+	for _, e := range entries {
+		name := strings.ToUpper(e.key)
+		sep, ok := separators[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s: %s: unknown config", e.at, e.key))
+		case name == "CONFIG_FILE":
+			errs = append(errs, fmt.Errorf("%s: %s: can only be set in the environment", e.at, e.key))
+		case name == "PROVIDERS":
+			providers, perrs := decodeProviders(e.node, e.lineAt)
+			fc.providers = append([]Provider{}, providers...)
+			errs = append(errs, perrs...)
+		case name == "API_KEYS" && isMapList(e.node):
+			keys, kerrs := decodeAPIKeys(e.node, e.lineAt)
+			fc.keys = append([]APIKey{}, keys...)
+			errs = append(errs, kerrs...)
+		default:
+			var v any
+			if err := e.node.Decode(&v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", e.at, e.key, err))
+				continue
+			}
+			value, err := envValue(v, sep)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", e.at, e.key, err))
+				continue
+			}
+			fc.values[name] = value
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, errors.Join(errs...))
+	}
+	return fc, nil
+}
+
+// fileEntry is a top level key of a config file, and its value
+type fileEntry struct {
+	key  string
+	node *yaml.Node
+	// at is where the key is, and lineAt where a line of its value is, for errors
+	at     string
+	lineAt func(line int) string
+}
+
+func yamlEntries(data []byte, path string) ([]fileEntry, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: must be a map of config by env name", path, root.Line)
+	}
+	lineAt := func(line int) string { return fmt.Sprintf("%s:%d", path, line) }
+	var entries []fileEntry
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i]
+		entries = append(entries, fileEntry{key: key.Value, node: root.Content[i+1], at: lineAt(key.Line), lineAt: lineAt})
+	}
+	return entries, nil
+}
+
+// tomlEntries reads toml, whose values are turned into yaml nodes. The toml parser doesn't tell the lines of keys,
+// so errors only refer to the file.
+func tomlEntries(data []byte, path string) ([]fileEntry, error) {
+	var raw map[string]any
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	lineAt := func(int) string { return path }
+	var entries []fileEntry
+	for k, v := range raw {
+		var n yaml.Node
+		if err := n.Encode(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		entries = append(entries, fileEntry{key: k, node: &n, at: path, lineAt: lineAt})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+func envValue(v any, sep string) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		var items []string
+		for _, item := range v {
+			s, err := envValue(item, sep)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, sep), nil
+	case map[string]any:
+		return "", errors.New("must be a value or a list of values")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// envSeparators returns the env names of AppConfig, with the separator of list values
+func envSeparators() map[string]string {
+	separators := map[string]string{}
+	t := reflect.TypeOf(AppConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		sep := f.Tag.Get("envSeparator")
+		if sep == "" {
+			sep = ","
+		}
+		separators[name] = sep
+	}
+	return separators
+}
+
+// envLists returns the env names of AppConfig that are lists
+func envLists() map[string]bool {
+	lists := map[string]bool{}
+	t := reflect.TypeOf(AppConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name := f.Tag.Get("env"); name != "" && f.Type.Kind() == reflect.Slice {
+			lists[name] = true
+		}
+	}
+	return lists
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_File(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+SERVICES:
+  - "sendgrid:SG.key"
+  - "mailjet:pub:secret"
+ALLOW_LIST: ["@example.com", "@example.org"]
+SHADOW_PERCENT: 2.5
+METRICS: false
+hedge_threshold: 500ms
+`,
+		"config.json": `{
+  "SERVICES": ["sendgrid:SG.key", "mailjet:pub:secret"],
+  "ALLOW_LIST": ["@example.com", "@example.org"],
+  "SHADOW_PERCENT": 2.5,
+  "METRICS": false,
+  "hedge_threshold": "500ms"
+}`,
+		"config.toml": `
+SERVICES = ["sendgrid:SG.key", "mailjet:pub:secret"]
+ALLOW_LIST = ["@example.com", "@example.org"]
+SHADOW_PERCENT = 2.5
+METRICS = false
+hedge_threshold = "500ms"
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", writeConfig(t, name, content))
+			t.Setenv("RETRY_STRATEGY", "each")
+			t.Setenv("METRICS", "true")
+
+			c, err := Load()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"sendgrid:SG.key", "mailjet:pub:secret"}, c.Services)
+			assert.Equal(t, []string{"@example.com", "@example.org"}, c.AllowListFilter)
+			assert.Equal(t, 2.5, c.ShadowPercent)
+			assert.Equal(t, 500*time.Millisecond, c.HedgeThreshold)
+			assert.False(t, c.Metrics, "the file overrides the environment")
+			assert.Equal(t, "each", c.RetryStrategy, "the environment is used for what the file doesn't set")
+		})
+	}
+}
+
+func TestLoad_InvalidFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", "SERVICE: [sendgrid]\nTENANTS_FILE: {a: b}\n"))
+	_, err := Load()
+	assert.ErrorContains(t, err, "SERVICE: unknown config")
+	assert.ErrorContains(t, err, "TENANTS_FILE: must be a value or a list of values")
+
+	t.Setenv("CONFIG_FILE", writeConfig(t, "config.yaml", "HEDGE_THRESHOLD: soon\n"))
+	_, err = Load()
+	assert.Error(t, err, "values are parsed like the environment")
+
+	t.Setenv("CONFIG_FILE", writeConfig(t, "config.ini", "SERVICES=sendgrid\n"))
+	_, err = Load()
+	assert.ErrorContains(t, err, "must be .yaml, .yml, .json or .toml")
+}
@@ -0,0 +1,174 @@
+package dedup
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var duplicates = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "posthook",
+	Name:      "duplicate_count",
+	Help:      "The total number of posthook events dropped as duplicates",
+}, []string{"name"})
+
+// Store remembers keys for a bounded amount of time
+type Store interface {
+	// Seen records the key and reports if it already was recorded, and not expired
+	Seen(key string) (bool, error)
+	// Forget removes the key, so it is no longer seen
+	Forget(key string) error
+}
+
+// Key identifies an event, it is empty for events that can't be deduplicated
+func Key(h mmailer.Posthook) string {
+	if h.EventId == "" {
+		return ""
+	}
+	return h.Service + ":" + h.EventId
+}
+
+// Deduper drops posthook events that has been seen before
+type Deduper struct {
+	store Store
+}
+
+func New(store Store) *Deduper {
+	return &Deduper{store: store}
+}
+
+// Filter returns the hooks that has not been seen before. Events without an event id are always returned.
+// If the store fails, the hooks it already recorded are forgotten, so none of them are dropped when redelivered.
+func (d *Deduper) Filter(hooks []mmailer.Posthook) ([]mmailer.Posthook, error) {
+	var res []mmailer.Posthook
+	for _, h := range hooks {
+		key := Key(h)
+		if key == "" {
+			res = append(res, h)
+			continue
+		}
+		seen, err := d.store.Seen(key)
+		if err != nil {
+			return nil, errors.Join(err, d.Forget(res))
+		}
+		if seen {
+			duplicates.WithLabelValues(h.Service).Inc()
+			continue
+		}
+		res = append(res, h)
+	}
+	return res, nil
+}
+
+// Forget makes the hooks unseen, eg. if they could not be handled after being filtered and
+// should be accepted when the vendor redelivers them.
+func (d *Deduper) Forget(hooks []mmailer.Posthook) error {
+	for _, h := range hooks {
+		key := Key(h)
+		if key == "" {
+			continue
+		}
+		err := d.store.Forget(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memEntry struct {
+	key  string
+	seen time.Time
+}
+
+type memStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	order    *list.List // oldest first
+	keys     map[string]*list.Element
+	now      func() time.Time
+}
+
+// NewMemStore remembers keys for ttl, but never more than capacity keys, the oldest are dropped first
+func NewMemStore(ttl time.Duration, capacity int) Store {
+	return newMemStore(ttl, capacity)
+}
+
+func newMemStore(ttl time.Duration, capacity int) *memStore {
+	return &memStore{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		keys:     map[string]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+func (m *memStore) Seen(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.seen(key, m.now()), nil
+}
+
+func (m *memStore) seen(key string, at time.Time) bool {
+	if m.has(key, at) {
+		return true
+	}
+	m.add(key, at)
+	return false
+}
+
+func (m *memStore) has(key string, at time.Time) bool {
+	m.expire(at)
+	_, ok := m.keys[key]
+	return ok
+}
+
+func (m *memStore) add(key string, at time.Time) {
+	m.keys[key] = m.order.PushBack(memEntry{key: key, seen: at})
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		m.remove(m.order.Front())
+	}
+}
+
+func (m *memStore) Forget(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forget(key)
+	return nil
+}
+
+func (m *memStore) forget(key string) {
+	if el, ok := m.keys[key]; ok {
+		m.remove(el)
+	}
+}
+
+func (m *memStore) expire(now time.Time) {
+	for el := m.order.Front(); el != nil; el = m.order.Front() {
+		if now.Sub(el.Value.(memEntry).seen) < m.ttl {
+			return
+		}
+		m.remove(el)
+	}
+}
+
+func (m *memStore) remove(el *list.Element) {
+	delete(m.keys, el.Value.(memEntry).key)
+	m.order.Remove(el)
+}
+
+func (m *memStore) entries() []memEntry {
+	var res []memEntry
+	for el := m.order.Front(); el != nil; el = el.Next() {
+		res = append(res, el.Value.(memEntry))
+	}
+	return res
+}
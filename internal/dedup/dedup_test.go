@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduper_Filter(t *testing.T) {
+	d := New(NewMemStore(time.Hour, 100))
+	hooks := []mmailer.Posthook{
+		{Service: "sendgrid", EventId: "1"},
+		{Service: "sendgrid", EventId: "2"},
+		{Service: "mailjet", EventId: "1"},
+		{Service: "generic"},
+	}
+	res, err := d.Filter(hooks)
+	require.NoError(t, err)
+	assert.Equal(t, hooks, res)
+
+	res, err = d.Filter(hooks)
+	require.NoError(t, err)
+	assert.Equal(t, []mmailer.Posthook{{Service: "generic"}}, res)
+
+	require.NoError(t, d.Forget(hooks[:1]))
+	res, err = d.Filter(hooks)
+	require.NoError(t, err)
+	assert.Equal(t, []mmailer.Posthook{hooks[0], hooks[3]}, res)
+}
+
+// failingStore fails to record the key fail
+type failingStore struct {
+	Store
+	fail string
+}
+
+func (f failingStore) Seen(key string) (bool, error) {
+	if key == f.fail {
+		return false, errors.New("store is down")
+	}
+	return f.Store.Seen(key)
+}
+
+func TestDeduper_FilterFails(t *testing.T) {
+	store := NewMemStore(time.Hour, 100)
+	hooks := []mmailer.Posthook{
+		{Service: "sendgrid", EventId: "1"},
+		{Service: "sendgrid", EventId: "2"},
+	}
+	_, err := New(failingStore{Store: store, fail: "sendgrid:2"}).Filter(hooks)
+	assert.EqualError(t, err, "store is down")
+
+	res, err := New(store).Filter(hooks)
+	require.NoError(t, err)
+	assert.Equal(t, hooks, res, "the keys recorded before the failure are forgotten")
+}
+
+func TestMemStore_Bounds(t *testing.T) {
+	m := newMemStore(time.Minute, 2)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	seen := func(key string) bool {
+		s, err := m.Seen(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	assert.False(t, seen("a"))
+	assert.True(t, seen("a"))
+	assert.False(t, seen("b"))
+	assert.False(t, seen("c")) // evicts a
+	assert.False(t, seen("a"))
+
+	now = now.Add(2 * time.Minute)
+	assert.False(t, seen("c"))
+}
+
+func TestFileStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup", "log")
+
+	s, err := NewFileStore(path, time.Hour, 100)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b c", "d\n"} {
+		seen, err := s.Seen(k)
+		require.NoError(t, err)
+		assert.False(t, seen)
+	}
+	require.NoError(t, s.Forget("a"))
+
+	s, err = NewFileStore(path, time.Hour, 100)
+	require.NoError(t, err)
+	for k, expected := range map[string]bool{"a": false, "b c": true, "d\n": true, "e": false} {
+		seen, err := s.Seen(k)
+		require.NoError(t, err)
+		assert.Equal(t, expected, seen, k)
+	}
+}
+
+func TestFileStore_WriteFails(t *testing.T) {
+	s, err := NewFileStore(filepath.Join(t.TempDir(), "log"), time.Hour, 100)
+	require.NoError(t, err)
+	f := s.(*fileStore)
+	require.NoError(t, f.file.Close())
+
+	_, err = s.Seen("a")
+	assert.Error(t, err)
+	assert.False(t, f.mem.has("a", f.mem.now()), "a key that could not be stored is not seen")
+}
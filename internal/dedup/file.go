@@ -0,0 +1,154 @@
+package dedup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modfin/mmailer/internal/logger"
+)
+
+type fileStore struct {
+	mu     sync.Mutex
+	mem    *memStore
+	path   string
+	file   *os.File
+	writes int
+}
+
+// NewFileStore is a memory store that also appends every key to a log file, which is replayed on startup
+// so duplicates are detected across restarts. The log is compacted as it grows.
+func NewFileStore(path string, ttl time.Duration, capacity int) (Store, error) {
+	f := &fileStore{
+		mem:  newMemStore(ttl, capacity),
+		path: path,
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("dedup: could not create dir: %w", err)
+	}
+	err = f.replay()
+	if err != nil {
+		return nil, err
+	}
+	err = f.compact()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Each line in the log is "<unix nano> <quoted key>", a zero timestamp means the key was forgotten
+func (f *fileStore) replay() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("dedup: could not open log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		ts, quoted, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		nano, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			continue
+		}
+		key, err := strconv.Unquote(quoted)
+		if err != nil {
+			continue
+		}
+		if nano == 0 {
+			f.mem.forget(key)
+			continue
+		}
+		f.mem.seen(key, time.Unix(0, nano))
+	}
+	f.mem.expire(f.mem.now())
+	return scanner.Err()
+}
+
+func (f *fileStore) compact() error {
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".dedup-*")
+	if err != nil {
+		return fmt.Errorf("dedup: could not compact log: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for _, e := range f.mem.entries() {
+		_, _ = fmt.Fprintf(w, "%d %s\n", e.seen.UnixNano(), strconv.Quote(e.key))
+	}
+	err = w.Flush()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("dedup: could not compact log: %w", err)
+	}
+
+	f.file, err = os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("dedup: could not open log: %w", err)
+	}
+	f.writes = 0
+	return nil
+}
+
+// append writes the line of the key to the log, and once it is written applies it to the memory store
+func (f *fileStore) append(nano int64, key string, apply func()) error {
+	_, err := fmt.Fprintf(f.file, "%d %s\n", nano, strconv.Quote(key))
+	if err != nil {
+		return fmt.Errorf("dedup: could not write log: %w", err)
+	}
+	f.mem.mu.Lock()
+	apply()
+	f.mem.mu.Unlock()
+	f.writes++
+	if f.writes > max(f.mem.order.Len(), 1000) {
+		// the key is already stored, so a failed compaction is not an error of it
+		if err := f.compact(); err != nil {
+			logger.Error(err, "dedup: could not compact log")
+		}
+	}
+	return nil
+}
+
+func (f *fileStore) Seen(key string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mem.mu.Lock()
+	now := f.mem.now()
+	seen := f.mem.has(key, now)
+	f.mem.mu.Unlock()
+	if seen {
+		return true, nil
+	}
+	return false, f.append(now.UnixNano(), key, func() {
+		f.mem.add(key, now)
+	})
+}
+
+func (f *fileStore) Forget(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.append(0, key, func() {
+		f.mem.forget(key)
+	})
+}
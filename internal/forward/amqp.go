@@ -0,0 +1,133 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/modfin/mmailer"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type amqpChannel interface {
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error)
+	IsClosed() bool
+	Close() error
+}
+
+type amqpSink struct {
+	name       string
+	exchange   string
+	routingKey string
+	connect    func() (amqpChannel, func() error, error)
+
+	mu        sync.Mutex
+	channel   amqpChannel
+	closeConn func() error
+}
+
+// NewAmqpSink publishes one persistent message per posthook to exchange, with the routing key, or the event type
+// if the routing key is empty. Publishes are confirmed by the broker before they are considered delivered.
+func NewAmqpSink(name string, uri string, exchange string, routingKey string) (PosthookSink, error) {
+	if _, err := amqp.ParseURI(uri); err != nil {
+		return nil, err
+	}
+	if exchange == "" && routingKey == "" {
+		return nil, errors.New("amqp sink: the default exchange requires a routing_key, naming the queue")
+	}
+	return &amqpSink{
+		name:       name,
+		exchange:   exchange,
+		routingKey: routingKey,
+		connect: func() (amqpChannel, func() error, error) {
+			conn, err := amqp.Dial(uri)
+			if err != nil {
+				return nil, nil, err
+			}
+			ch, err := conn.Channel()
+			if err == nil {
+				err = ch.Confirm(false)
+			}
+			if err != nil {
+				_ = conn.Close()
+				return nil, nil, err
+			}
+			return ch, conn.Close, nil
+		},
+	}, nil
+}
+
+func (a *amqpSink) Name() string {
+	return a.name
+}
+
+func (a *amqpSink) Write(ctx context.Context, hooks []mmailer.Posthook) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil || a.channel.IsClosed() {
+		a.close()
+		ch, closeConn, err := a.connect()
+		if err != nil {
+			return err
+		}
+		a.channel, a.closeConn = ch, closeConn
+	}
+
+	var confirms []*amqp.DeferredConfirmation
+	for _, h := range hooks {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return Permanent(err)
+		}
+		key := a.routingKey
+		if key == "" {
+			key = h.Event.String()
+		}
+		confirm, err := a.channel.PublishWithDeferredConfirmWithContext(ctx, a.exchange, key, false, false, amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    h.EventId,
+			Timestamp:    time.Now(),
+			Body:         data,
+		})
+		if err != nil {
+			a.close()
+			return err
+		}
+		confirms = append(confirms, confirm)
+	}
+	for _, c := range confirms {
+		if c == nil {
+			continue
+		}
+		ok, err := c.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("amqp sink: broker did not acknowledge message")
+		}
+	}
+	return nil
+}
+
+func (a *amqpSink) close() {
+	if a.channel != nil {
+		_ = a.channel.Close()
+		a.channel = nil
+	}
+	if a.closeConn != nil {
+		_ = a.closeConn()
+		a.closeConn = nil
+	}
+}
+
+func (a *amqpSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.close()
+	return nil
+}
@@ -0,0 +1,212 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modfin/mmailer"
+)
+
+type FileOptions struct {
+	// MaxSize rotates the file when it has grown to at least this many bytes, 0 disables it
+	MaxSize int64
+	// MaxAge rotates the file when it is this old, 0 disables it
+	MaxAge time.Duration
+	// Keep is the number of rotated files to keep, the oldest are removed first, 0 keeps all of them
+	Keep int
+}
+
+type fileSink struct {
+	name string
+	path string
+	opts FileOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+// NewFileSink appends posthooks as json lines to path. Rotated files are renamed to path.<timestamp>
+func NewFileSink(name string, path string, opts FileOptions) (PosthookSink, error) {
+	if path == "" {
+		return nil, errors.New("file sink: no path")
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("file sink: %w", err)
+	}
+	return &fileSink{
+		name: name,
+		path: path,
+		opts: opts,
+		now:  time.Now,
+	}, nil
+}
+
+func parseFileOptions(opts url.Values) (FileOptions, error) {
+	var fo FileOptions
+	var err error
+	if v := opts.Get("max_size"); v != "" {
+		fo.MaxSize, err = parseSize(v)
+		if err != nil {
+			return fo, fmt.Errorf("file sink: bad max_size: %w", err)
+		}
+	}
+	if v := opts.Get("max_age"); v != "" {
+		fo.MaxAge, err = time.ParseDuration(v)
+		if err != nil {
+			return fo, fmt.Errorf("file sink: bad max_age: %w", err)
+		}
+	}
+	if v := opts.Get("keep"); v != "" {
+		fo.Keep, err = strconv.Atoi(v)
+		if err != nil {
+			return fo, fmt.Errorf("file sink: bad keep: %w", err)
+		}
+	}
+	return fo, nil
+}
+
+// parseSize parses sizes such as 512, 64KB, 100MB or 1GB
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+func (f *fileSink) Name() string {
+	return f.name
+}
+
+func (f *fileSink) Write(_ context.Context, hooks []mmailer.Posthook) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.open()
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f.file)
+	var n int64
+	for _, h := range hooks {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return Permanent(err)
+		}
+		data = append(data, '\n')
+		written, _ := w.Write(data)
+		n += int64(written)
+	}
+	err = w.Flush()
+	f.size += n
+	if err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *fileSink) open() error {
+	if f.file != nil && !f.shouldRotate() {
+		return nil
+	}
+	if f.file != nil {
+		err := f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("file sink: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("file sink: %w", err)
+	}
+	f.file = file
+	f.size = stat.Size()
+	f.created = f.now()
+	if f.size > 0 {
+		// Appending to an existing file, its age counts from when it was last modified
+		f.created = stat.ModTime()
+	}
+	if f.shouldRotate() {
+		return f.open()
+	}
+	return nil
+}
+
+func (f *fileSink) shouldRotate() bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size >= f.opts.MaxSize {
+		return true
+	}
+	return f.opts.MaxAge > 0 && f.now().Sub(f.created) >= f.opts.MaxAge
+}
+
+func (f *fileSink) rotate() error {
+	_ = f.file.Close()
+	f.file = nil
+
+	rotated := fmt.Sprintf("%s.%s", f.path, f.now().UTC().Format("20060102T150405.000000000"))
+	err := os.Rename(f.path, rotated)
+	if err != nil {
+		return fmt.Errorf("file sink: could not rotate: %w", err)
+	}
+	if f.opts.Keep <= 0 {
+		return nil
+	}
+
+	old, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil
+	}
+	sort.Strings(old)
+	for len(old) > f.opts.Keep {
+		_ = os.Remove(old[0])
+		old = old[1:]
+	}
+	return nil
+}
+
+func (f *fileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
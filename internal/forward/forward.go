@@ -0,0 +1,468 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var forwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "posthook",
+	Name:      "forward_count",
+	Help:      "The total number of posthook events forwarded, by target and status",
+}, []string{"target", "status"})
+
+type Options struct {
+	// BatchSize is the max number of events sent in one request
+	BatchSize int
+	// BatchWait is how long to wait for a batch to fill up before sending what there is
+	BatchWait time.Duration
+	// MaxAttempts is the number of delivery attempts before an event is moved to the dead letters
+	MaxAttempts int
+	// Backoff is the delay after the first failed attempt, it is doubled for each following attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout of each write to a sink
+	Timeout time.Duration
+	// Dir makes the queues durable by storing them on disk, if empty they are kept in memory
+	Dir string
+}
+
+func (o Options) withDefaults() Options {
+	if o.BatchSize < 1 {
+		o.BatchSize = 1
+	}
+	if o.MaxAttempts < 1 {
+		o.MaxAttempts = 1
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.MaxBackoff < o.Backoff {
+		o.MaxBackoff = o.Backoff
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// Forwarder queues posthooks and delivers them to each of its targets in the background,
+// with batching, retries and a dead letter store for events that can't be delivered.
+type Forwarder struct {
+	workers []*worker
+	rules   atomic.Pointer[Rules]
+	wg      sync.WaitGroup
+	stop    chan struct{}
+}
+
+func New(opts Options, targets ...Target) (*Forwarder, error) {
+	opts = opts.withDefaults()
+
+	f := &Forwarder{stop: make(chan struct{})}
+	names := map[string]bool{}
+	for _, t := range targets {
+		name := t.Sink.Name()
+		if name == "" || names[name] || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("forward: sink name %q is not unique or not valid", name)
+		}
+		names[name] = true
+
+		var store Store
+		if opts.Dir == "" {
+			store = NewMemStore()
+		} else {
+			err := moveQueue(opts.Dir, name, t.Previous)
+			if err != nil {
+				return nil, err
+			}
+			store, err = NewDirStore(filepath.Join(opts.Dir, name))
+			if err != nil {
+				return nil, err
+			}
+		}
+		f.workers = append(f.workers, &worker{
+			target: t,
+			opts:   opts,
+			store:  store,
+			wake:   make(chan struct{}, 1),
+			stop:   f.stop,
+		})
+	}
+	if opts.Dir != "" {
+		orphans(opts.Dir, names)
+	}
+	return f, nil
+}
+
+// moveQueue renames the queue of a sink from a previous name, unless it already has a queue by its name
+func moveQueue(dir, name string, previous []string) error {
+	if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+		return nil
+	}
+	for _, p := range previous {
+		if p == name {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			continue
+		}
+		err := os.Rename(filepath.Join(dir, p), filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("forward: could not move queue %s to %s: %w", p, name, err)
+		}
+		logger.Info(fmt.Sprintf("posthook forward: moved queue %s to %s", p, name))
+		return nil
+	}
+	return nil
+}
+
+// orphans warns about the queues in dir that belong to no sink, their events are not delivered
+func orphans(dir string, names map[string]bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Error(err, "posthook forward: could not list queues")
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() && !names[e.Name()] && !strings.HasPrefix(e.Name(), ".") {
+			logger.Warn(fmt.Sprintf("posthook forward: the queue %s belongs to no sink, its events are not delivered", filepath.Join(dir, e.Name())))
+		}
+	}
+}
+
+// Start delivering queued events, including the ones left on disk from a previous run
+func (f *Forwarder) Start() {
+	for _, w := range f.workers {
+		f.wg.Add(1)
+		go func(w *worker) {
+			defer f.wg.Done()
+			w.run()
+		}(w)
+	}
+}
+
+// Stop waits for in flight deliveries to finish and closes the sinks. Events still in queue are kept if the store is durable
+func (f *Forwarder) Stop() {
+	close(f.stop)
+	f.wg.Wait()
+	for _, w := range f.workers {
+		if c, ok := w.target.Sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				logger.Error(err, "posthook forward: could not close sink", "sink", w.target.Sink.Name())
+			}
+		}
+	}
+}
+
+// SetRules replaces the routing rules, nil routes every event to every sink.
+// Rules referring to unknown sinks are refused, and the current rules are kept.
+func (f *Forwarder) SetRules(r *Rules) error {
+	if r != nil {
+		names := slicez.Map(f.workers, func(w *worker) string {
+			return w.name()
+		})
+		if err := r.validate(names); err != nil {
+			return fmt.Errorf("forward: invalid routing rules: %w", err)
+		}
+	}
+	f.rules.Store(r)
+	return nil
+}
+
+// EnqueueError is the error of Enqueue when some of the events could not be queued
+type EnqueueError struct {
+	// Failed are the events missing from the queue of at least one of their targets, the others are queued
+	Failed []mmailer.Posthook
+	Err    error
+}
+
+func (e *EnqueueError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EnqueueError) Unwrap() error {
+	return e.Err
+}
+
+// Enqueue adds the posthooks to the queue of every target they are routed to and whose filter matches.
+// Once it returns without error the events are as durable as the configured store. If some could not be
+// queued, an *EnqueueError tells which.
+func (f *Forwarder) Enqueue(hooks []mmailer.Posthook) error {
+	routes := f.route(hooks)
+
+	var errs []error
+	failed := make([]bool, len(hooks))
+	for _, w := range f.workers {
+		var matching []mmailer.Posthook
+		var indices []int
+		for i, h := range hooks {
+			if routes[i].to(w.name()) && w.target.Filter.Match(h) {
+				matching = append(matching, h)
+				indices = append(indices, i)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+		err := w.store.Push(slicez.Map(matching, NewEntry)...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("forward: could not enqueue for %s: %w", w.name(), err))
+			for _, i := range indices {
+				failed[i] = true
+			}
+			continue
+		}
+		w.notify()
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	var res []mmailer.Posthook
+	for i, h := range hooks {
+		if failed[i] {
+			res = append(res, h)
+		}
+	}
+	return &EnqueueError{Failed: res, Err: errors.Join(errs...)}
+}
+
+type route struct {
+	all   bool
+	sinks []string
+}
+
+func (r route) to(sink string) bool {
+	return r.all || slicez.Contains(r.sinks, sink)
+}
+
+func (f *Forwarder) route(hooks []mmailer.Posthook) []route {
+	rules := f.rules.Load()
+	routes := make([]route, len(hooks))
+	for i, h := range hooks {
+		if rules == nil {
+			routes[i] = route{all: true}
+			continue
+		}
+		rule, ok := rules.Match(h)
+		switch {
+		case !ok:
+			sinks, all := rules.Route(h)
+			routes[i] = route{all: all, sinks: sinks}
+		case rule.Drop:
+			routed.WithLabelValues(rule.Name, "drop").Inc()
+		default:
+			routed.WithLabelValues(rule.Name, "forward").Inc()
+			routes[i] = route{sinks: rule.Sinks}
+		}
+	}
+	return routes
+}
+
+type DeadLetters struct {
+	Target  string  `json:"target"`
+	Entries []Entry `json:"entries"`
+}
+
+// Dead lists the dead letters of all targets
+func (f *Forwarder) Dead() ([]DeadLetters, error) {
+	var res []DeadLetters
+	for _, w := range f.workers {
+		entries, err := w.store.Dead()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, DeadLetters{Target: w.name(), Entries: entries})
+	}
+	return res, nil
+}
+
+// Revive moves a dead letter of target back into its queue
+func (f *Forwarder) Revive(target string, id string) error {
+	for _, w := range f.workers {
+		if w.name() != target {
+			continue
+		}
+		err := w.store.Revive(id)
+		if err != nil {
+			return err
+		}
+		w.notify()
+		return nil
+	}
+	return ErrNotFound
+}
+
+type worker struct {
+	target Target
+	opts   Options
+	store  Store
+	wake   chan struct{}
+	stop   chan struct{}
+}
+
+func (w *worker) name() string {
+	return w.target.Sink.Name()
+}
+
+func (w *worker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sleep returns false if the forwarder is stopped while waiting
+func (w *worker) sleep(d time.Duration, wakeable bool) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	wake := w.wake
+	if !wakeable {
+		wake = nil
+	}
+	select {
+	case <-w.stop:
+		return false
+	case <-wake:
+		return true
+	case <-t.C:
+		return true
+	}
+}
+
+func (w *worker) run() {
+	var batchStarted time.Time
+	for {
+		entries, err := w.store.Peek(w.opts.BatchSize)
+		if err != nil {
+			logger.Error(err, "posthook forward: could not read queue", "target", w.name())
+			if !w.sleep(w.opts.Backoff, false) {
+				return
+			}
+			continue
+		}
+		if len(entries) == 0 {
+			batchStarted = time.Time{}
+			if !w.sleep(time.Minute, true) {
+				return
+			}
+			continue
+		}
+
+		// Give the batch a chance to fill up, unless it is old enough already
+		if len(entries) < w.opts.BatchSize && w.opts.BatchWait > 0 {
+			if batchStarted.IsZero() {
+				batchStarted = time.Now()
+			}
+			if wait := w.opts.BatchWait - time.Since(batchStarted); wait > 0 {
+				if !w.sleep(wait, true) {
+					return
+				}
+				continue
+			}
+		}
+		batchStarted = time.Time{}
+
+		ok := w.process(entries)
+		if !ok {
+			if !w.sleep(w.backoff(entries), false) {
+				return
+			}
+		}
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+	}
+}
+
+// process delivers a batch, and returns false if the worker should back off before the next attempt
+func (w *worker) process(entries []Entry) bool {
+	err := w.deliver(entries)
+	if err == nil {
+		forwarded.WithLabelValues(w.name(), "success").Add(float64(len(entries)))
+		w.ack(entries)
+		return true
+	}
+
+	if isPermanent(err) && len(entries) > 1 {
+		// The sink refused the batch, try the events one by one so only the poison ones are retried
+		logger.Warn("posthook forward: batch rejected, delivering events one by one", "target", w.name(), "error", err)
+		allOk := true
+		for _, e := range entries {
+			allOk = w.process([]Entry{e}) && allOk
+		}
+		return allOk
+	}
+
+	logger.Error(err, "posthook forward: could not deliver events", "target", w.name(), "events", len(entries))
+	forwarded.WithLabelValues(w.name(), "error").Add(float64(len(entries)))
+	var retry, dead []Entry
+	for _, e := range entries {
+		e.Attempts++
+		e.LastError = err.Error()
+		if e.Attempts >= w.opts.MaxAttempts {
+			dead = append(dead, e)
+			continue
+		}
+		retry = append(retry, e)
+	}
+	if len(retry) > 0 {
+		if err := w.store.Update(retry...); err != nil {
+			logger.Error(err, "posthook forward: could not update queue", "target", w.name())
+		}
+	}
+	if len(dead) > 0 {
+		logger.Warn(fmt.Sprintf("posthook forward: giving up on %d event(s), moving to dead letters", len(dead)), "target", w.name())
+		forwarded.WithLabelValues(w.name(), "dead").Add(float64(len(dead)))
+		if err := w.store.Bury(dead...); err != nil {
+			logger.Error(err, "posthook forward: could not move events to dead letters", "target", w.name())
+		}
+	}
+	return false
+}
+
+func (w *worker) ack(entries []Entry) {
+	ids := slicez.Map(entries, func(e Entry) string {
+		return e.Id
+	})
+	if err := w.store.Ack(ids...); err != nil {
+		logger.Error(err, "posthook forward: could not remove delivered events from queue", "target", w.name())
+	}
+}
+
+func (w *worker) backoff(entries []Entry) time.Duration {
+	attempts := 0
+	for _, e := range entries {
+		attempts = max(attempts, e.Attempts)
+	}
+	d := w.opts.Backoff
+	for i := 1; i < attempts && d < w.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, w.opts.MaxBackoff)
+}
+
+func (w *worker) deliver(entries []Entry) error {
+	hooks := slicez.Map(entries, func(e Entry) mmailer.Posthook {
+		return e.Hook
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), w.opts.Timeout)
+	defer cancel()
+	return w.target.Sink.Write(ctx, hooks)
+}
@@ -0,0 +1,214 @@
+package forward
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receiver struct {
+	mu     sync.Mutex
+	got    [][]mmailer.Posthook
+	status func(hooks []mmailer.Posthook) int
+	header http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	var hooks []mmailer.Posthook
+	_ = json.Unmarshal(body, &hooks)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.header = req.Header.Clone()
+	status := http.StatusOK
+	if r.status != nil {
+		status = r.status(hooks)
+	}
+	if status == http.StatusOK {
+		r.got = append(r.got, hooks)
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) batches() [][]mmailer.Posthook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]mmailer.Posthook{}, r.got...)
+}
+
+func hooks(ids ...string) []mmailer.Posthook {
+	var res []mmailer.Posthook
+	for _, id := range ids {
+		res = append(res, mmailer.Posthook{Service: "test", EventId: id, Event: mmailer.EventDelivered})
+	}
+	return res
+}
+
+func TestForwarder_Batches(t *testing.T) {
+	r := &receiver{}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	f, err := New(Options{BatchSize: 3, BatchWait: 50 * time.Millisecond}, Target{Sink: NewHTTPSink("t", srv.URL, "s3cret", 0)})
+	require.NoError(t, err)
+	require.NoError(t, f.Enqueue(hooks("1", "2", "3", "4")))
+	f.Start()
+	defer f.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(r.batches()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	b := r.batches()
+	assert.Len(t, b[0], 3)
+	assert.Len(t, b[1], 1)
+	assert.Equal(t, "4", b[1][0].EventId)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.NotEmpty(t, r.header.Get(SignatureHeader))
+	assert.NotEmpty(t, r.header.Get(TimestampHeader))
+}
+
+func TestForwarder_RetriesAndDeadLetters(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	r := &receiver{status: func(hooks []mmailer.Posthook) int {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return http.StatusServiceUnavailable
+		}
+		for _, h := range hooks {
+			if h.EventId == "poison" {
+				return http.StatusBadRequest
+			}
+		}
+		return http.StatusOK
+	}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	f, err := New(Options{BatchSize: 10, MaxAttempts: 2, Backoff: time.Millisecond}, Target{Sink: NewHTTPSink("t", srv.URL, "", 0)})
+	require.NoError(t, err)
+	require.NoError(t, f.Enqueue(hooks("1", "poison", "2")))
+	f.Start()
+	defer f.Stop()
+
+	assert.Eventually(t, func() bool {
+		dead, err := f.Dead()
+		return err == nil && len(dead[0].Entries) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	var delivered []string
+	for _, b := range r.batches() {
+		for _, h := range b {
+			delivered = append(delivered, h.EventId)
+		}
+	}
+	assert.ElementsMatch(t, []string{"1", "2"}, delivered)
+
+	dead, _ := f.Dead()
+	assert.Equal(t, "t", dead[0].Target)
+	assert.Equal(t, "poison", dead[0].Entries[0].Hook.EventId)
+	assert.Equal(t, 2, dead[0].Entries[0].Attempts)
+	assert.Contains(t, dead[0].Entries[0].LastError, "400")
+
+	assert.ErrorIs(t, f.Revive("t", "unknown"), ErrNotFound)
+}
+
+func TestForwarder_Filter(t *testing.T) {
+	all := &fakeSink{name: "all"}
+	bounces := &fakeSink{name: "bounces"}
+	f, err := New(Options{BatchSize: 10},
+		Target{Sink: all},
+		Target{Sink: bounces, Filter: Filter{Events: []mmailer.PosthookEvent{mmailer.EventBounce}}},
+	)
+	require.NoError(t, err)
+	require.NoError(t, f.Enqueue([]mmailer.Posthook{
+		{EventId: "1", Event: mmailer.EventOpen},
+		{EventId: "2", Event: mmailer.EventBounce},
+	}))
+	f.Start()
+	defer f.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(all.written()) == 2 && len(bounces.written()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "2", bounces.written()[0].EventId)
+}
+
+// fullStore refuses every push
+type fullStore struct {
+	Store
+}
+
+func (fullStore) Push(...Entry) error {
+	return errors.New("disk is full")
+}
+
+func TestForwarder_EnqueueFails(t *testing.T) {
+	f, err := New(Options{BatchSize: 10},
+		Target{Sink: &fakeSink{name: "all"}},
+		Target{Sink: &fakeSink{name: "bounces"}, Filter: Filter{Events: []mmailer.PosthookEvent{mmailer.EventBounce}}},
+	)
+	require.NoError(t, err)
+	f.workers[1].store = fullStore{Store: f.workers[1].store}
+
+	err = f.Enqueue([]mmailer.Posthook{
+		{EventId: "1", Event: mmailer.EventOpen},
+		{EventId: "2", Event: mmailer.EventBounce},
+	})
+	var eerr *EnqueueError
+	require.ErrorAs(t, err, &eerr)
+	assert.ErrorContains(t, err, "could not enqueue for bounces: disk is full")
+	assert.Equal(t, []mmailer.Posthook{{EventId: "2", Event: mmailer.EventBounce}}, eerr.Failed)
+}
+
+func TestNew_MovesPreviousQueue(t *testing.T) {
+	dir := t.TempDir()
+	old, err := NewDirStore(filepath.Join(dir, "legacy"))
+	require.NoError(t, err)
+	require.NoError(t, old.Push(NewEntry(mmailer.Posthook{EventId: "1"})))
+
+	sink := &fakeSink{name: "crm"}
+	f, err := New(Options{Dir: dir}, Target{Sink: sink, Previous: []string{"legacy"}})
+	require.NoError(t, err)
+	f.Start()
+	defer f.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(sink.written()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.NoDirExists(t, filepath.Join(dir, "legacy"))
+}
+
+func TestNew_UniqueNames(t *testing.T) {
+	_, err := New(Options{}, Target{Sink: &fakeSink{name: "a"}}, Target{Sink: &fakeSink{name: "a"}})
+	assert.Error(t, err)
+	_, err = New(Options{}, Target{Sink: &fakeSink{name: "../a"}})
+	assert.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	body := []byte(`[{"event":"delivered"}]`)
+	sig := Sign("secret", ts, body)
+
+	assert.True(t, Verify("secret", "1700000000", body, sig))
+	assert.False(t, Verify("other", "1700000000", body, sig))
+	assert.False(t, Verify("secret", "1700000001", body, sig))
+	assert.False(t, Verify("secret", "1700000000", []byte("[]"), sig))
+}
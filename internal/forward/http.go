@@ -0,0 +1,78 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/modfin/mmailer"
+)
+
+type httpSink struct {
+	name   string
+	url    string
+	secret func() string
+	client *http.Client
+}
+
+// NewHTTPSink POSTs batches of posthooks as a json array to url, signed with secret if not empty
+func NewHTTPSink(name string, url string, secret string, timeout time.Duration) PosthookSink {
+	return newHTTPSink(name, url, func() string { return secret }, timeout)
+}
+
+// newHTTPSink signs with the secret returned for each batch, so it may change
+func newHTTPSink(name string, url string, secret func() string, timeout time.Duration) *httpSink {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &httpSink{
+		name:   name,
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *httpSink) Name() string {
+	return h.name
+}
+
+func (h *httpSink) Write(ctx context.Context, hooks []mmailer.Posthook) error {
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := h.secret(); secret != "" {
+		now := time.Now()
+		req.Header.Set(TimestampHeader, fmt.Sprintf("%d", now.Unix()))
+		req.Header.Set(SignatureHeader, Sign(secret, now, data))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	err = fmt.Errorf("target responded %d: %s", resp.StatusCode, string(body))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return Permanent(err)
+	default:
+		return err
+	}
+}
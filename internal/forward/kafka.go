@@ -0,0 +1,63 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/modfin/mmailer"
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type kafkaSink struct {
+	name   string
+	writer kafkaWriter
+}
+
+// NewKafkaSink produces one message per posthook to topic, keyed by message id so that
+// all events of a message end up in the same partition, in order.
+func NewKafkaSink(name string, brokers []string, topic string) (PosthookSink, error) {
+	if len(brokers) == 0 || brokers[0] == "" {
+		return nil, errors.New("kafka sink: no brokers")
+	}
+	if topic == "" {
+		return nil, errors.New("kafka sink: no topic")
+	}
+	return &kafkaSink{
+		name: name,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}, nil
+}
+
+func (k *kafkaSink) Name() string {
+	return k.name
+}
+
+func (k *kafkaSink) Write(ctx context.Context, hooks []mmailer.Posthook) error {
+	msgs := make([]kafka.Message, 0, len(hooks))
+	for _, h := range hooks {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return Permanent(err)
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(h.Id()),
+			Value: data,
+		})
+	}
+	return k.writer.WriteMessages(ctx, msgs...)
+}
+
+func (k *kafkaSink) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,89 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/nats-io/nats.go"
+)
+
+type natsConn interface {
+	Publish(subject string, data []byte) error
+	FlushWithContext(ctx context.Context) error
+	Close()
+}
+
+type natsSink struct {
+	name    string
+	subject string
+	connect func() (natsConn, error)
+
+	mu   sync.Mutex
+	conn natsConn
+}
+
+// NewNatsSink publishes one message per posthook on the subject given by the url path.
+// Dots in the path can be written as slashes, ie. nats://localhost:4222/mmailer/posthooks publishes on mmailer.posthooks
+func NewNatsSink(name string, u *url.URL, timeout time.Duration) (PosthookSink, error) {
+	subject := strings.ReplaceAll(strings.Trim(u.Path, "/"), "/", ".")
+	if subject == "" {
+		return nil, errors.New("nats sink: no subject")
+	}
+	server := *u
+	server.Path = ""
+	server.RawPath = ""
+	addr := server.String()
+	return &natsSink{
+		name:    name,
+		subject: subject,
+		connect: func() (natsConn, error) {
+			return nats.Connect(addr, nats.Name("mmailer"), nats.Timeout(timeout))
+		},
+	}, nil
+}
+
+func (n *natsSink) Name() string {
+	return n.name
+}
+
+func (n *natsSink) Write(ctx context.Context, hooks []mmailer.Posthook) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// The connection is created lazily, so a broker that is down at startup doesn't stop mmailerd
+	if n.conn == nil {
+		conn, err := n.connect()
+		if err != nil {
+			return err
+		}
+		n.conn = conn
+	}
+	for _, h := range hooks {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return Permanent(err)
+		}
+		err = n.conn.Publish(n.subject, data)
+		if err != nil {
+			return err
+		}
+	}
+	// Flushing makes sure the server actually got the messages before they are removed from the queue
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *natsSink) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/rules"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var routed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "posthook",
+	Name:      "routed_count",
+	Help:      "The total number of posthook events matched by a routing rule, by rule and action",
+}, []string{"rule", "action"})
+
+// Rule matches posthooks on their fields, empty fields matches everything.
+// A matching event is forwarded to Sinks, or dropped if Drop is set.
+type Rule struct {
+	Name             string                  `yaml:"name" json:"name"`
+	Events           []mmailer.PosthookEvent `yaml:"events" json:"events"`
+	Services         []string                `yaml:"services" json:"services"`
+	RecipientDomains []string                `yaml:"recipient_domains" json:"recipient_domains"`
+	Tags             []string                `yaml:"tags" json:"tags"` // matches events with any of the tags
+	Sinks            []string                `yaml:"sinks" json:"sinks"`
+	Drop             bool                    `yaml:"drop" json:"drop"`
+}
+
+func (r Rule) Match(h mmailer.Posthook) bool {
+	if len(r.Events) > 0 && !slicez.Contains(r.Events, h.Event) {
+		return false
+	}
+	if len(r.Services) > 0 && !slicez.ContainsBy(r.Services, func(s string) bool {
+		return strings.EqualFold(s, h.Service)
+	}) {
+		return false
+	}
+	if len(r.RecipientDomains) > 0 && !rules.MatchDomain(r.RecipientDomains, h.Email) {
+		return false
+	}
+	if len(r.Tags) > 0 && !rules.MatchAny(r.Tags, h.Tags) {
+		return false
+	}
+	return true
+}
+
+// Rules decides which sinks get which events. The first matching rule wins, events that matches
+// no rule goes to the Default sinks, or to all sinks if no default is given.
+// The filters of the sinks are applied after the routing.
+type Rules rules.Set[Rule]
+
+// LoadRules reads rules from a yaml, or json, file
+func LoadRules(path string) (*Rules, error) {
+	s, err := rules.Load(path, func(r *Rule) *string { return &r.Name })
+	if err != nil {
+		return nil, err
+	}
+	return (*Rules)(s), nil
+}
+
+// validate checks that the rules only refers to known sinks
+func (r *Rules) validate(sinks []string) error {
+	var errs []error
+	unknown := func(rule string, names []string) {
+		for _, n := range names {
+			if !slicez.Contains(sinks, n) {
+				errs = append(errs, fmt.Errorf("%s: unknown sink %q", rule, n))
+			}
+		}
+	}
+	for _, rule := range r.Rules {
+		if rule.Drop && len(rule.Sinks) > 0 {
+			errs = append(errs, fmt.Errorf("%s: can't both drop and forward to sinks", rule.Name))
+		}
+		if !rule.Drop && len(rule.Sinks) == 0 {
+			errs = append(errs, fmt.Errorf("%s: has to either drop or forward to sinks", rule.Name))
+		}
+		unknown(rule.Name, rule.Sinks)
+	}
+	unknown("default", r.Default)
+	return errors.Join(errs...)
+}
+
+// Route returns the sinks the event should be forwarded to, all is true if it goes to all of them
+func (r *Rules) Route(h mmailer.Posthook) (sinks []string, all bool) {
+	rule, ok := r.Match(h)
+	if !ok {
+		if len(r.Default) == 0 {
+			return nil, true
+		}
+		return r.Default, false
+	}
+	return rule.Sinks, false
+}
+
+// Match returns the first rule that matches the event
+func (r *Rules) Match(h mmailer.Posthook) (Rule, bool) {
+	return rules.First(r.Rules, h)
+}
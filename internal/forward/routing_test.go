@@ -0,0 +1,99 @@
+package forward
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `
+rules:
+  - name: drop-engagement
+    events: [open, click]
+    drop: true
+  - name: outlook-bounces
+    events: [bounce]
+    recipient_domains: ["@outlook.com"]
+    sinks: [crm, archive]
+  - name: newsletter
+    services: [SendGrid]
+    tags: [newsletter]
+    sinks: [archive]
+default: [crm]
+`
+
+func writeRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRules_Route(t *testing.T) {
+	r, err := LoadRules(writeRules(t, testRules))
+	require.NoError(t, err)
+	require.NoError(t, r.validate([]string{"crm", "archive"}))
+
+	tests := []struct {
+		hook  mmailer.Posthook
+		rule  string
+		sinks []string
+	}{
+		{hook: mmailer.Posthook{Event: mmailer.EventOpen}, rule: "drop-engagement"},
+		{hook: mmailer.Posthook{Event: mmailer.EventBounce, Email: "a@outlook.com"}, rule: "outlook-bounces", sinks: []string{"crm", "archive"}},
+		{hook: mmailer.Posthook{Event: mmailer.EventBounce, Email: "a@gmail.com"}, sinks: []string{"crm"}},
+		{hook: mmailer.Posthook{Event: mmailer.EventDelivered, Service: "sendgrid", Tags: []string{"weekly", "newsletter"}}, rule: "newsletter", sinks: []string{"archive"}},
+		{hook: mmailer.Posthook{Event: mmailer.EventDelivered, Service: "mailjet", Tags: []string{"newsletter"}}, sinks: []string{"crm"}},
+	}
+	for _, tc := range tests {
+		rule, _ := r.Match(tc.hook)
+		assert.Equal(t, tc.rule, rule.Name)
+		sinks, all := r.Route(tc.hook)
+		assert.False(t, all)
+		if !rule.Drop {
+			assert.Equal(t, tc.sinks, sinks)
+		}
+	}
+}
+
+func TestRules_Invalid(t *testing.T) {
+	_, err := LoadRules(writeRules(t, "rules:\n  - evnts: [open]\n"))
+	assert.Error(t, err)
+
+	r, err := LoadRules(writeRules(t, testRules))
+	require.NoError(t, err)
+	assert.Error(t, r.validate([]string{"crm"}))
+
+	r, err = LoadRules(writeRules(t, "rules:\n  - events: [open]\n"))
+	require.NoError(t, err)
+	assert.Error(t, r.validate(nil))
+}
+
+func TestForwarder_Rules(t *testing.T) {
+	crm := &fakeSink{name: "crm"}
+	archive := &fakeSink{name: "archive"}
+	f, err := New(Options{}, Target{Sink: crm}, Target{Sink: archive})
+	require.NoError(t, err)
+
+	r, err := LoadRules(writeRules(t, testRules))
+	require.NoError(t, err)
+	require.NoError(t, f.SetRules(r))
+	assert.Error(t, f.SetRules(&Rules{Default: []string{"unknown"}}))
+
+	require.NoError(t, f.Enqueue([]mmailer.Posthook{
+		{EventId: "1", Event: mmailer.EventOpen},
+		{EventId: "2", Event: mmailer.EventBounce, Email: "a@outlook.com"},
+		{EventId: "3", Event: mmailer.EventDelivered},
+	}))
+	f.Start()
+	defer f.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(crm.written()) == 2 && len(archive.written()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "2", archive.written()[0].EventId)
+}
@@ -0,0 +1,36 @@
+package forward
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Mmailer-Signature"
+	TimestampHeader = "X-Mmailer-Timestamp"
+)
+
+// Sign returns the value of the signature header for a payload sent at ts.
+// The signature is a hex encoded HMAC-SHA256 of "<unix timestamp>.<body>", keyed with secret,
+// so a receiver can both verify the sender and reject replayed requests.
+func Sign(secret string, ts time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value, as produced by Sign, for the timestamp header value and body
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	expected := Sign(secret, time.Unix(unix, 0), body)
+	return hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature)))
+}
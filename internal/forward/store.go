@@ -0,0 +1,333 @@
+package forward
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modfin/mmailer"
+)
+
+// Entry is a single posthook waiting to be, or failed to be, delivered to a target
+type Entry struct {
+	Id        string           `json:"id"`
+	Attempts  int              `json:"attempts"`
+	LastError string           `json:"last_error,omitempty"`
+	Created   time.Time        `json:"created"`
+	Hook      mmailer.Posthook `json:"hook"`
+}
+
+var seq atomic.Uint64
+
+func NewEntry(hook mmailer.Posthook) Entry {
+	now := time.Now()
+	return Entry{
+		// Ids are prefixed with the creation time and a sequence so that sorting them by name gives FIFO order
+		Id:      fmt.Sprintf("%020d-%010d-%s", now.UnixNano(), seq.Add(1)%1e10, uuid.NewString()),
+		Created: now,
+		Hook:    hook,
+	}
+}
+
+var ErrNotFound = errors.New("entry not found")
+
+// Store holds the queue of pending entries for one target, and the entries that has been given up on (dead letters)
+type Store interface {
+	// Push appends entries to the end of the queue
+	Push(entries ...Entry) error
+	// Peek returns, up to n, of the oldest entries in the queue without removing them
+	Peek(n int) ([]Entry, error)
+	// Update persists changes, eg. attempts, to entries that are still in the queue
+	Update(entries ...Entry) error
+	// Ack removes delivered entries from the queue
+	Ack(ids ...string) error
+	// Bury moves entries from the queue to the dead letters
+	Bury(entries ...Entry) error
+	// Dead lists the dead letters
+	Dead() ([]Entry, error)
+	// Revive moves a dead letter back to the end of the queue, resetting its attempts
+	Revive(id string) error
+}
+
+type memStore struct {
+	mu    sync.Mutex
+	queue []Entry
+	dead  []Entry
+}
+
+// NewMemStore returns a Store that only lives in memory, ie. pending entries are lost on restart
+func NewMemStore() Store {
+	return &memStore{}
+}
+
+func (m *memStore) Push(entries ...Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = append(m.queue, entries...)
+	return nil
+}
+
+func (m *memStore) Peek(n int) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n = min(n, len(m.queue))
+	res := make([]Entry, n)
+	copy(res, m.queue[:n])
+	return res, nil
+}
+
+func (m *memStore) Update(entries ...Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range entries {
+		for i := range m.queue {
+			if m.queue[i].Id == e.Id {
+				m.queue[i] = e
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memStore) Ack(ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = without(m.queue, ids...)
+	return nil
+}
+
+func (m *memStore) Bury(entries ...Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range entries {
+		m.queue = without(m.queue, e.Id)
+		m.dead = append(m.dead, e)
+	}
+	return nil
+}
+
+func (m *memStore) Dead() ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]Entry, len(m.dead))
+	copy(res, m.dead)
+	return res, nil
+}
+
+func (m *memStore) Revive(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.dead {
+		if e.Id == id {
+			m.dead = without(m.dead, id)
+			e.Attempts = 0
+			e.LastError = ""
+			m.queue = append(m.queue, e)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func without(entries []Entry, ids ...string) []Entry {
+	res := entries[:0]
+	for _, e := range entries {
+		drop := false
+		for _, id := range ids {
+			if e.Id == id {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+type dirStore struct {
+	mu    sync.Mutex
+	queue string
+	dead  string
+}
+
+// NewDirStore returns a Store that persists every entry as a json file in dir,
+// pending entries in dir/queue and dead letters in dir/dead
+func NewDirStore(dir string) (Store, error) {
+	d := &dirStore{
+		queue: filepath.Join(dir, "queue"),
+		dead:  filepath.Join(dir, "dead"),
+	}
+	for _, p := range []string{d.queue, d.dead} {
+		err := os.MkdirAll(p, 0o750)
+		if err != nil {
+			return nil, fmt.Errorf("forward: could not create store dir: %w", err)
+		}
+	}
+	return d, nil
+}
+
+func (d *dirStore) Push(entries ...Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range entries {
+		err := writeEntry(d.queue, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dirStore) Peek(n int) ([]Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return readEntries(d.queue, n)
+}
+
+func (d *dirStore) Update(entries ...Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range entries {
+		if _, err := os.Stat(entryPath(d.queue, e.Id)); err != nil {
+			continue
+		}
+		err := writeEntry(d.queue, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dirStore) Ack(ids ...string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		err := os.Remove(entryPath(d.queue, id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dirStore) Bury(entries ...Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range entries {
+		err := writeEntry(d.dead, e)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(entryPath(d.queue, e.Id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dirStore) Dead() ([]Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return readEntries(d.dead, -1)
+}
+
+func (d *dirStore) Revive(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, err := readEntry(entryPath(d.dead, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	e.Attempts = 0
+	e.LastError = ""
+	err = writeEntry(d.queue, e)
+	if err != nil {
+		return err
+	}
+	return os.Remove(entryPath(d.dead, id))
+}
+
+func entryPath(dir string, id string) string {
+	return filepath.Join(dir, filepath.Base(id)+".json")
+}
+
+// writeEntry writes to a temp file and renames it, so a crash never leaves a half written entry behind
+func writeEntry(dir string, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), entryPath(dir, e.Id))
+}
+
+func readEntry(path string) (Entry, error) {
+	var e Entry
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(data, &e)
+	return e, err
+}
+
+// readEntries reads up to n entries, oldest first, n < 0 reads all of them
+func readEntries(dir string, n int) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	if n >= 0 && len(names) > n {
+		names = names[:n]
+	}
+
+	var res []Entry
+	for _, name := range names {
+		e, err := readEntry(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("forward: could not read entry %s: %w", name, err)
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
@@ -0,0 +1,67 @@
+package forward
+
+import (
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, s Store) {
+	a := NewEntry(mmailer.Posthook{EventId: "a"})
+	b := NewEntry(mmailer.Posthook{EventId: "b"})
+	c := NewEntry(mmailer.Posthook{EventId: "c"})
+	require.NoError(t, s.Push(a, b, c))
+
+	peek, err := s.Peek(2)
+	require.NoError(t, err)
+	require.Len(t, peek, 2)
+	assert.Equal(t, "a", peek[0].Hook.EventId)
+	assert.Equal(t, "b", peek[1].Hook.EventId)
+
+	a.Attempts = 3
+	require.NoError(t, s.Update(a))
+	require.NoError(t, s.Ack(b.Id))
+	require.NoError(t, s.Bury(c))
+
+	peek, err = s.Peek(10)
+	require.NoError(t, err)
+	require.Len(t, peek, 1)
+	assert.Equal(t, 3, peek[0].Attempts)
+
+	dead, err := s.Dead()
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "c", dead[0].Hook.EventId)
+
+	require.NoError(t, s.Revive(c.Id))
+	assert.ErrorIs(t, s.Revive(c.Id), ErrNotFound)
+
+	peek, err = s.Peek(10)
+	require.NoError(t, err)
+	require.Len(t, peek, 2)
+	assert.Equal(t, "c", peek[1].Hook.EventId)
+
+	dead, err = s.Dead()
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDirStore(dir)
+	require.NoError(t, err)
+	testStore(t, s)
+
+	// A new store on the same dir picks up where the old one left off
+	s, err = NewDirStore(dir)
+	require.NoError(t, err)
+	peek, err := s.Peek(10)
+	require.NoError(t, err)
+	assert.Len(t, peek, 2)
+}
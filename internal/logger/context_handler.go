@@ -21,9 +21,10 @@ type ContextHandler struct {
 	slog.Handler
 }
 
+// InitializeLogger makes logger the default, with the secrets given to Redact replaced in everything it logs
 func InitializeLogger(logger *slog.Logger) {
 	once.Do(func() {
-		slog.SetDefault(logger)
+		slog.SetDefault(slog.New(&redactHandler{logger.Handler()}))
 	})
 }
 
@@ -65,7 +66,7 @@ func InfoCtx(ctx context.Context, msg string, args ...any) {
 func (c *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
 	if attrs, ok := ctx.Value(logFields).([]slog.Attr); ok {
 		for _, v := range attrs {
-			r.AddAttrs(v)
+			r.AddAttrs(redactAttr(v))
 		}
 	}
 
@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const redacted = "[REDACTED]"
+
+// minSecretLength is the length below which secrets are not redacted, they would mangle unrelated parts of the logs
+const minSecretLength = 4
+
+var (
+	secretsMu sync.Mutex
+	secrets   = map[string]bool{}
+	replacer  atomic.Pointer[strings.Replacer]
+)
+
+// Redact makes the secrets be replaced by [REDACTED] in everything logged from now on
+func Redact(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if len(v) >= minSecretLength {
+			secrets[v] = true
+		}
+	}
+	var sorted []string
+	for s := range secrets {
+		sorted = append(sorted, s)
+	}
+	// the longest first, so a secret containing another is replaced as a whole
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var oldnew []string
+	for _, s := range sorted {
+		oldnew = append(oldnew, s, redacted)
+	}
+	replacer.Store(strings.NewReplacer(oldnew...))
+}
+
+func redact(s string) string {
+	r := replacer.Load()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// redactHandler replaces the secrets in the message and attributes of the records
+type redactHandler struct {
+	slog.Handler
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	if replacer.Load() == nil {
+		return h.Handler.Handle(ctx, r)
+	}
+	nr := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, nr)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return &redactHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redactedAttrs := make([]any, len(attrs))
+		for i, ga := range attrs {
+			redactedAttrs[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, redactedAttrs...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			if s := redact(x.Error()); s != x.Error() {
+				return slog.Any(a.Key, errors.New(s))
+			}
+		case fmt.Stringer:
+			if s := redact(x.String()); s != x.String() {
+				return slog.String(a.Key, s)
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
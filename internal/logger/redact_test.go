@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(&redactHandler{&ContextHandler{slog.NewTextHandler(&buf, nil)}})
+
+	Redact("SG.secret", "SG.secret.longer", "ab")
+	ctx := AddToLogContext(context.Background(), "url", "https://x/send?key=SG.secret")
+	l.InfoContext(ctx, "sending")
+	l.With("key", "SG.secret.longer").Error("sending with SG.secret",
+		"err", errors.New("401 for SG.secret"),
+		slog.Group("service", "api_key", "SG.secret"),
+		"kind", "ab")
+
+	out := buf.String()
+	require.NotEmpty(t, out)
+	assert.NotContains(t, out, "SG.secret")
+	assert.Contains(t, out, `key=[REDACTED]`)
+	assert.Contains(t, out, `msg="sending with [REDACTED]"`)
+	assert.Contains(t, out, `err="401 for [REDACTED]"`)
+	assert.Contains(t, out, `service.api_key=[REDACTED]`)
+	assert.Contains(t, out, `url="https://x/send?key=[REDACTED]"`)
+	assert.Contains(t, out, "kind=ab", "too short secrets are not redacted")
+}
@@ -0,0 +1,129 @@
+package mailbox
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/smtpx"
+	"github.com/modfin/mmailer/services"
+)
+
+// Message is a captured email, as the MIME message it renders to
+type Message struct {
+	Id         string    `json:"id"`
+	Service    string    `json:"service"`
+	Created    time.Time `json:"created"`
+	From       string    `json:"from"`
+	Recipients []string  `json:"recipients"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+	// ServiceConfig are the settings, eg. the ip pool, the service would have been given. The message is rendered
+	// by mmailer, not by the service, so they are not applied to it.
+	ServiceConfig []mmailer.ConfigItem `json:"service_config,omitempty"`
+	Raw           []byte               `json:"-"`
+	// Email is what the message was rendered from
+	Email mmailer.Email `json:"-"`
+}
+
+// Mailbox keeps the latest captured messages in memory, dropping the oldest when full
+type Mailbox struct {
+	mu       sync.RWMutex
+	capacity int
+	messages []Message
+}
+
+func New(capacity int) *Mailbox {
+	return &Mailbox{capacity: max(capacity, 1)}
+}
+
+// Capture renders the email and stores it as if it was sent by service. Raw emails are stored as is,
+// and parsed for Message.Email.
+func (m *Mailbox) Capture(service string, email mmailer.Email) ([]mmailer.Response, error) {
+	id := uuid.NewString()
+	raw, err := render(id, email)
+	if err != nil {
+		return nil, err
+	}
+	if len(email.Raw) > 0 {
+		parsed, err := smtpx.Parse(bytes.NewReader(raw))
+		if err == nil {
+			parsed.From, parsed.To, parsed.Cc = email.From, email.To, email.Cc
+			email = parsed
+		}
+	}
+	m.Add(Message{
+		Id:            id,
+		Service:       service,
+		Created:       time.Now(),
+		From:          email.From.Email,
+		Recipients:    smtpx.Recipients(email),
+		Subject:       email.Subject,
+		Size:          len(raw),
+		ServiceConfig: services.ConfigFor(service, email.ServiceConfig),
+		Raw:           raw,
+		Email:         email,
+	})
+
+	return slicez.Map(email.To, func(a mmailer.Address) mmailer.Response {
+		return mmailer.Response{Service: service, MessageId: id, Email: a.Email}
+	}), nil
+}
+
+func render(id string, email mmailer.Email) ([]byte, error) {
+	if len(email.Raw) > 0 {
+		return email.Raw, nil
+	}
+	message, err := smtpx.FromEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	message.SetHeader("Message-ID", fmt.Sprintf("<%s@mmailer>", id))
+	return message.Bytes()
+}
+
+func (m *Mailbox) Add(msg Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	if over := len(m.messages) - m.capacity; over > 0 {
+		m.messages = slices.Delete(m.messages, 0, over)
+	}
+}
+
+// List returns the messages newest first, only the ones sent to recipient if not empty
+func (m *Mailbox) List(recipient string) []Message {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Message{}
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if recipient != "" && !slicez.ContainsBy(msg.Recipients, func(r string) bool {
+			return strings.EqualFold(r, recipient)
+		}) {
+			continue
+		}
+		res = append(res, msg)
+	}
+	return res
+}
+
+func (m *Mailbox) Get(id string) (Message, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slicez.Find(m.messages, func(msg Message) bool {
+		return msg.Id == id
+	})
+}
+
+func (m *Mailbox) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,46 @@
+package mailbox
+
+import (
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func email(to string, subject string) mmailer.Email {
+	return mmailer.Email{
+		From:    mmailer.Address{Email: "jon@example.com"},
+		To:      []mmailer.Address{{Email: to}},
+		Subject: subject,
+		Text:    "Hi",
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	box := New(2)
+	res, err := box.Capture("sendgrid", email("a@example.com", "first"))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "sendgrid", res[0].Service)
+	assert.Equal(t, "a@example.com", res[0].Email)
+
+	msg, ok := box.Get(res[0].MessageId)
+	require.True(t, ok)
+	assert.Equal(t, "first", msg.Subject)
+	assert.Contains(t, string(msg.Raw), "Subject: first")
+	assert.Contains(t, string(msg.Raw), "Message-ID: <"+msg.Id+"@mmailer>")
+	assert.Equal(t, len(msg.Raw), msg.Size)
+
+	_, _ = box.Capture("sendgrid", email("b@example.com", "second"))
+	_, _ = box.Capture("mailjet", email("a@example.com", "third"))
+
+	list := box.List("")
+	require.Len(t, list, 2, "the oldest is dropped")
+	assert.Equal(t, "third", list[0].Subject)
+	assert.Equal(t, "second", list[1].Subject)
+	assert.Len(t, box.List("A@example.com"), 1)
+
+	box.Clear()
+	assert.Empty(t, box.List(""))
+}
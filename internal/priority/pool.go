@@ -0,0 +1,116 @@
+package priority
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "mmailer",
+	Subsystem: "priority",
+	Name:      "queued",
+	Help:      "The number of emails waiting for a worker, by priority class",
+}, []string{"class"})
+
+var busy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "mmailer",
+	Subsystem: "priority",
+	Name:      "busy_workers",
+	Help:      "The number of workers sending an email, by priority class",
+}, []string{"class"})
+
+var rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "priority",
+	Name:      "rejected_count",
+	Help:      "The total number of emails rejected since the queue was full, by priority class",
+}, []string{"class"})
+
+var wait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "mmailer",
+	Subsystem: "priority",
+	Name:      "wait_seconds",
+	Help:      "The time emails waited in the queue for a worker, by priority class",
+}, []string{"class"})
+
+var ErrQueueFull = errors.New("queue is full")
+
+const (
+	jobQueued int32 = iota
+	jobRunning
+	jobCanceled
+)
+
+type job struct {
+	ctx    context.Context
+	fn     func(ctx context.Context)
+	state  atomic.Int32
+	done   chan struct{}
+	queued time.Time
+}
+
+// Pool runs jobs by a fixed number of workers, taking them in order from a bounded queue.
+// Each priority class has its own pool, so one class can't hold up another.
+type Pool struct {
+	class string
+	jobs  chan *job
+}
+
+// NewPool starts the workers of the pool, which run until the process exits
+func NewPool(class string, workers, queue int) *Pool {
+	p := &Pool{
+		class: class,
+		jobs:  make(chan *job, max(queue, 0)),
+	}
+	for i := 0; i < max(workers, 1); i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	for j := range p.jobs {
+		queued.WithLabelValues(p.class).Dec()
+		if !j.state.CompareAndSwap(jobQueued, jobRunning) {
+			continue
+		}
+		wait.WithLabelValues(p.class).Observe(time.Since(j.queued).Seconds())
+		busy.WithLabelValues(p.class).Inc()
+		func() {
+			defer close(j.done)
+			defer busy.WithLabelValues(p.class).Dec()
+			j.fn(j.ctx)
+		}()
+	}
+}
+
+// Do runs fn by a worker of the pool and waits for it to return. It returns ErrQueueFull at once if the queue is
+// full, or the error of ctx if it is done before a worker took the job, fn is then not run.
+func (p *Pool) Do(ctx context.Context, fn func(ctx context.Context)) error {
+	j := &job{ctx: ctx, fn: fn, done: make(chan struct{}), queued: time.Now()}
+	queued.WithLabelValues(p.class).Inc()
+	select {
+	case p.jobs <- j:
+	default:
+		queued.WithLabelValues(p.class).Dec()
+		rejected.WithLabelValues(p.class).Inc()
+		return ErrQueueFull
+	}
+
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		if j.state.CompareAndSwap(jobQueued, jobCanceled) {
+			return ctx.Err()
+		}
+		// already running, fn sees the context is done
+		<-j.done
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package priority
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// block submits jobs that run until release is closed, and returns when the workers are busy with them
+func block(p *Pool, workers, jobs int, release chan struct{}) {
+	started := make(chan struct{}, jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			_ = p.Do(context.Background(), func(ctx context.Context) {
+				started <- struct{}{}
+				<-release
+			})
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		<-started
+	}
+}
+
+func TestPool_QueueFull(t *testing.T) {
+	p := NewPool("test-full", 1, 1)
+	release := make(chan struct{})
+	defer close(release)
+	block(p, 1, 1, release)
+	go func() { _ = p.Do(context.Background(), func(ctx context.Context) {}) }()
+
+	require.Eventually(t, func() bool { return len(p.jobs) == 1 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, p.Do(context.Background(), func(ctx context.Context) {}), ErrQueueFull)
+}
+
+func TestPool_Canceled(t *testing.T) {
+	p := NewPool("test-canceled", 1, 2)
+	release := make(chan struct{})
+	block(p, 1, 1, release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ran := false
+	err := p.Do(ctx, func(ctx context.Context) { ran = true })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, p.Do(context.Background(), func(ctx context.Context) {}))
+	assert.False(t, ran, "a job canceled while queued is not run")
+}
+
+func TestPool_NoStarvation(t *testing.T) {
+	bulk, critical := NewPool("test-bulk", 2, 100), NewPool("test-critical", 1, 1)
+	release := make(chan struct{})
+	defer close(release)
+	block(bulk, 2, 102, release)
+
+	done := make(chan error)
+	go func() {
+		done <- critical.Do(context.Background(), func(ctx context.Context) {})
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("critical email waited for bulk")
+	}
+}
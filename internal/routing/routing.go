@@ -0,0 +1,149 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/rules"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var matched = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "routing",
+	Name:      "matched_count",
+	Help:      "The total number of emails routed, by the rule that matched, or default",
+}, []string{"rule"})
+
+// Rule matches emails on their fields, empty fields matches everything.
+// A matching email is sent by one of Services, chosen by the select strategy.
+type Rule struct {
+	Name        string   `yaml:"name" json:"name"`
+	FromDomains []string `yaml:"from_domains" json:"from_domains"`
+	// RecipientDomains matches emails with any to or cc recipient in one of the domains
+	RecipientDomains []string `yaml:"recipient_domains" json:"recipient_domains"`
+	// Tags matches emails with any of the tags
+	Tags []string `yaml:"tags" json:"tags"`
+	// ServiceConfig matches emails with all the config items, an empty value matches any value
+	ServiceConfig map[mmailer.ConfigKey]string `yaml:"service_config" json:"service_config"`
+	Services      []string                     `yaml:"services" json:"services"`
+}
+
+func (r Rule) Match(e mmailer.Email) bool {
+	if len(r.FromDomains) > 0 && !rules.MatchDomain(r.FromDomains, e.From.Email) {
+		return false
+	}
+	if len(r.RecipientDomains) > 0 && !slicez.ContainsBy(slicez.Concat(e.To, e.Cc), func(a mmailer.Address) bool {
+		return rules.MatchDomain(r.RecipientDomains, a.Email)
+	}) {
+		return false
+	}
+	if len(r.Tags) > 0 && !rules.MatchAny(r.Tags, e.Tags) {
+		return false
+	}
+	for k, v := range r.ServiceConfig {
+		if !slicez.ContainsBy(e.ServiceConfig, func(c mmailer.ConfigItem) bool {
+			return c.Key == k && (v == "" || c.Value == v)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rules narrows down the services that may send an email. The first matching rule wins, emails that
+// matches no rule may be sent by the Default services, or by any service if no default is given.
+type Rules rules.Set[Rule]
+
+// Load reads rules from a yaml, or json, file
+func Load(path string) (*Rules, error) {
+	s, err := rules.Load(path, func(r *Rule) *string { return &r.Name })
+	if err != nil {
+		return nil, err
+	}
+	return (*Rules)(s), nil
+}
+
+// Validate checks that the rules only refers to known services
+func (r *Rules) Validate(services []string) error {
+	var errs []error
+	unknown := func(rule string, names []string) {
+		for _, n := range names {
+			if !slicez.ContainsBy(services, func(s string) bool {
+				return strings.EqualFold(s, n)
+			}) {
+				errs = append(errs, fmt.Errorf("%s: unknown service %q", rule, n))
+			}
+		}
+	}
+	for _, rule := range r.Rules {
+		if len(rule.Services) == 0 {
+			errs = append(errs, fmt.Errorf("%s: has no services", rule.Name))
+		}
+		unknown(rule.Name, rule.Services)
+	}
+	unknown("default", r.Default)
+	return errors.Join(errs...)
+}
+
+// Match returns the first rule that matches the email
+func (r *Rules) Match(e mmailer.Email) (Rule, bool) {
+	return rules.First(r.Rules, e)
+}
+
+// Explanation is how an email is routed
+type Explanation struct {
+	// Rule is the name of the matching rule, or empty if the default was used
+	Rule string `json:"rule"`
+	// Services are the ones the rule, or default, allows, nil is any
+	Services []string `json:"services"`
+	// Candidates are the allowed services that can send the email, which selection picks from
+	Candidates []string `json:"candidates"`
+	// Fallback is set when no allowed service can send the email, so any service that can is used
+	Fallback bool `json:"fallback"`
+}
+
+// Explain routes the email among services, which are the ones that can send it
+func (r *Rules) Explain(e mmailer.Email, services []mmailer.Service) Explanation {
+	var ex Explanation
+	rule, ok := r.Match(e)
+	if ok {
+		ex.Rule, ex.Services = rule.Name, rule.Services
+	} else if len(r.Default) > 0 {
+		ex.Services = r.Default
+	}
+
+	candidates := services
+	if ex.Services != nil {
+		candidates = slicez.Filter(services, func(s mmailer.Service) bool {
+			return slicez.ContainsBy(ex.Services, func(n string) bool {
+				return strings.EqualFold(n, s.Name())
+			})
+		})
+	}
+	if len(candidates) == 0 {
+		ex.Fallback = true
+		candidates = services
+	}
+	ex.Candidates = slicez.Map(candidates, func(s mmailer.Service) string {
+		return s.Name()
+	})
+	return ex
+}
+
+// Route is an mmailer.RouteStrategy
+func (r *Rules) Route(e mmailer.Email, services []mmailer.Service) []mmailer.Service {
+	ex := r.Explain(e, services)
+	rule := ex.Rule
+	if rule == "" {
+		rule = "default"
+	}
+	matched.WithLabelValues(rule).Inc()
+	return slicez.Filter(services, func(s mmailer.Service) bool {
+		return slicez.Contains(ex.Candidates, s.Name())
+	})
+}
@@ -0,0 +1,133 @@
+package routing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `
+rules:
+  - name: microsoft
+    recipient_domains: ["@outlook.com", "hotmail.com"]
+    services: [mailgun]
+  - name: invoices
+    from_domains: [billing.example.com]
+    tags: [invoice]
+    services: [sendgrid, mandrill]
+  - name: marketing-pool
+    service_config: {X-IpPool: marketing}
+    services: [mailjet]
+  - name: tracking-off
+    service_config: {X-Disable-Tracking: ""}
+    services: [mandrill]
+default: [sendgrid, mailjet]
+`
+
+type service struct {
+	name string
+}
+
+func (s *service) Name() string                     { return s.name }
+func (s *service) CanSend(email mmailer.Email) bool { return true }
+func (s *service) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	return []mmailer.Response{{Service: s.name}}, nil
+}
+func (s *service) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) { return nil, nil }
+
+func services(names ...string) []mmailer.Service {
+	return slicez.Map(names, func(n string) mmailer.Service {
+		return &service{name: n}
+	})
+}
+
+func writeRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRules_Explain(t *testing.T) {
+	r, err := Load(writeRules(t, testRules))
+	require.NoError(t, err)
+	require.NoError(t, r.Validate([]string{"mailgun", "sendgrid", "mandrill", "mailjet"}))
+	all := services("mailgun", "sendgrid", "mandrill", "mailjet")
+
+	email := func(from string, to string, modify func(e *mmailer.Email)) mmailer.Email {
+		e := mmailer.NewEmail()
+		e.From = mmailer.Address{Email: from}
+		e.To = []mmailer.Address{{Email: "jane@example.com"}, {Email: to}}
+		if modify != nil {
+			modify(&e)
+		}
+		return e
+	}
+
+	tests := []struct {
+		name       string
+		email      mmailer.Email
+		rule       string
+		candidates []string
+	}{
+		{"recipient domain", email("jon@example.com", "a@outlook.com", nil), "microsoft", []string{"mailgun"}},
+		{"tag and from domain", email("jon@billing.example.com", "a@example.com", func(e *mmailer.Email) {
+			e.Tags = []string{"monthly", "invoice"}
+		}), "invoices", []string{"sendgrid", "mandrill"}},
+		{"tag from other domain", email("jon@example.com", "a@example.com", func(e *mmailer.Email) {
+			e.Tags = []string{"invoice"}
+		}), "", []string{"sendgrid", "mailjet"}},
+		{"config value", email("jon@example.com", "a@example.com", func(e *mmailer.Email) {
+			e.ServiceConfig = []mmailer.ConfigItem{{Key: mmailer.IpPool, Value: "marketing"}}
+		}), "marketing-pool", []string{"mailjet"}},
+		{"config present", email("jon@example.com", "a@example.com", func(e *mmailer.Email) {
+			e.DisableTracking()
+		}), "tracking-off", []string{"mandrill"}},
+		{"default", email("jon@example.com", "a@example.com", nil), "", []string{"sendgrid", "mailjet"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ex := r.Explain(tc.email, all)
+			assert.Equal(t, tc.rule, ex.Rule)
+			assert.Equal(t, tc.candidates, ex.Candidates)
+			assert.False(t, ex.Fallback)
+		})
+	}
+
+	ex := r.Explain(email("jon@example.com", "a@outlook.com", nil), services("sendgrid", "mailjet"))
+	assert.Equal(t, "microsoft", ex.Rule)
+	assert.True(t, ex.Fallback, "mailgun can't send it")
+	assert.Equal(t, []string{"sendgrid", "mailjet"}, ex.Candidates)
+}
+
+func TestRules_Validate(t *testing.T) {
+	r, err := Load(writeRules(t, testRules))
+	require.NoError(t, err)
+	err = r.Validate([]string{"sendgrid", "mandrill", "mailjet"})
+	assert.ErrorContains(t, err, `microsoft: unknown service "mailgun"`)
+
+	_, err = Load(writeRules(t, "rules:\n  - name: x\n    recipients: [a]\n"))
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestFacade_Routing(t *testing.T) {
+	r, err := Load(writeRules(t, testRules))
+	require.NoError(t, err)
+	f := mmailer.New(mmailer.SelectRandom, mmailer.RetryNone, services("mailgun", "sendgrid", "mandrill", "mailjet")...)
+	f.Routing = r.Route
+
+	for i := 0; i < 10; i++ {
+		res, err := f.Send(context.Background(), mmailer.Email{To: []mmailer.Address{{Email: "a@outlook.com"}}}, "")
+		require.NoError(t, err)
+		assert.Equal(t, "mailgun", res[0].Service)
+	}
+
+	res, err := f.Send(context.Background(), mmailer.Email{To: []mmailer.Address{{Email: "a@outlook.com"}}}, "mandrill")
+	require.NoError(t, err)
+	assert.Equal(t, "mandrill", res[0].Service, "a preferred service overrides routing")
+}
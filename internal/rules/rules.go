@@ -0,0 +1,70 @@
+// Package rules has what the routing rules of emails and of posthooks share, so that the two formats
+// are read and matched alike.
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/modfin/henry/slicez"
+	"gopkg.in/yaml.v3"
+)
+
+// Set is a list of rules, of which the first that matches wins, and the Default names of what values
+// that matches no rule goes to
+type Set[R any] struct {
+	Rules   []R      `yaml:"rules" json:"rules"`
+	Default []string `yaml:"default" json:"default"`
+}
+
+// Load reads a set of rules from a yaml, or json, file. Unknown fields are errors, and rules without
+// a name, which name returns, are named by their index, eg. rule-0
+func Load[R any](path string, name func(*R) *string) (*Set[R], error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Set[R]
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(&s)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse routing rules %s: %w", path, err)
+	}
+	for i := range s.Rules {
+		if n := name(&s.Rules[i]); *n == "" {
+			*n = fmt.Sprintf("rule-%d", i)
+		}
+	}
+	return &s, nil
+}
+
+// First returns the first of the rules that matches v
+func First[R interface{ Match(T) bool }, T any](rules []R, v T) (R, bool) {
+	for _, rule := range rules {
+		if rule.Match(v) {
+			return rule, true
+		}
+	}
+	var zero R
+	return zero, false
+}
+
+// MatchDomain tells if the address is in one of the domains, which may be prefixed by @
+func MatchDomain(domains []string, address string) bool {
+	_, domain, _ := strings.Cut(address, "@")
+	return slicez.ContainsBy(domains, func(d string) bool {
+		return strings.EqualFold(strings.TrimPrefix(d, "@"), domain)
+	})
+}
+
+// MatchAny tells if any of want is in have, eg. the tags of a rule among those of an email
+func MatchAny(want, have []string) bool {
+	return slicez.ContainsBy(want, func(w string) bool {
+		return slicez.Contains(have, w)
+	})
+}
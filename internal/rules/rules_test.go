@@ -0,0 +1,57 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rule struct {
+	Name    string   `yaml:"name"`
+	Domains []string `yaml:"domains"`
+}
+
+func (r rule) Match(address string) bool {
+	return MatchDomain(r.Domains, address)
+}
+
+func load(t *testing.T, content string) (*Set[rule], error) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return Load(path, func(r *rule) *string { return &r.Name })
+}
+
+func TestLoad(t *testing.T) {
+	s, err := load(t, "rules:\n  - name: microsoft\n    domains: ['@outlook.com', hotmail.com]\n  - domains: [example.com]\ndefault: [a]\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"microsoft", "rule-1"}, []string{s.Rules[0].Name, s.Rules[1].Name})
+	assert.Equal(t, []string{"a"}, s.Default)
+
+	r, ok := First(s.Rules, "someone@Hotmail.com")
+	assert.True(t, ok)
+	assert.Equal(t, "microsoft", r.Name)
+	r, ok = First(s.Rules, "someone@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "rule-1", r.Name)
+	_, ok = First(s.Rules, "someone@example.org")
+	assert.False(t, ok)
+
+	s, err = load(t, `{"rules": [{"domains": ["example.com"]}]}`)
+	require.NoError(t, err)
+	assert.Equal(t, "rule-0", s.Rules[0].Name, "json is yaml too")
+	s, err = load(t, "")
+	require.NoError(t, err)
+	assert.Empty(t, s.Rules)
+
+	_, err = load(t, "rules:\n  - domain: [example.com]\n")
+	assert.ErrorContains(t, err, "could not parse routing rules")
+}
+
+func TestMatchAny(t *testing.T) {
+	assert.True(t, MatchAny([]string{"invoice", "receipt"}, []string{"receipt"}))
+	assert.False(t, MatchAny([]string{"invoice"}, []string{"newsletter"}))
+	assert.False(t, MatchAny([]string{"invoice"}, nil))
+}
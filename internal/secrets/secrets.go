@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IsReference tells if s refers to a secret, by file://, vault:// or env:, rather than being a value
+func IsReference(s string) bool {
+	return strings.HasPrefix(s, "file://") || strings.HasPrefix(s, "vault://") || strings.HasPrefix(s, "env:")
+}
+
+// Resolver resolves references to secrets, and remembers them to tell if they have changed
+type Resolver struct {
+	// VaultAddr is the address of a Vault compatible HTTP API, eg. https://vault.example.com:8200, and VaultToken
+	// its token
+	VaultAddr  string
+	VaultToken string
+	Client     *http.Client
+
+	mu       sync.Mutex
+	resolved map[string]string
+}
+
+func New(vaultAddr, vaultToken string) *Resolver {
+	return &Resolver{
+		VaultAddr:  strings.TrimSuffix(vaultAddr, "/"),
+		VaultToken: vaultToken,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		resolved:   map[string]string{},
+	}
+}
+
+// Resolve returns the secret ref refers to, or ref if it isn't a reference
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	if !IsReference(ref) {
+		return ref, nil
+	}
+	value, err := r.fetch(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.resolved[ref] = value
+	r.mu.Unlock()
+	return value, nil
+}
+
+// Values returns the secrets that have been resolved
+func (r *Resolver) Values() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var values []string
+	for _, v := range r.resolved {
+		values = append(values, v)
+	}
+	return values
+}
+
+// Changed resolves the references again, and tells if any of them refers to another secret than before
+func (r *Resolver) Changed(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	resolved := make(map[string]string, len(r.resolved))
+	for ref, v := range r.resolved {
+		resolved[ref] = v
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for ref, v := range resolved {
+		value, err := r.fetch(ctx, ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if value != v {
+			return true, nil
+		}
+	}
+	return false, errors.Join(errs...)
+}
+
+func (r *Resolver) fetch(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "file://"):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, "file://"))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", ref, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%s: %s is not set", ref, name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "vault://"):
+		value, err := r.vault(ctx, strings.TrimPrefix(ref, "vault://"))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", ref, err)
+		}
+		return value, nil
+	}
+	return ref, nil
+}
+
+// vault reads the field of the secret at path#field, of a kv version 1 or 2 engine
+func (r *Resolver) vault(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || field == "" {
+		return "", errors.New("must be vault://<path>#<field>")
+	}
+	if r.VaultAddr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.VaultAddr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.VaultToken)
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return "", fmt.Errorf("vault responded %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("could not decode vault response: %w", err)
+	}
+	data := secret.Data
+	// kv version 2 has the secret in data.data, next to its metadata
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, meta := data["metadata"]; meta {
+			data = inner
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("the secret has no field %s", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
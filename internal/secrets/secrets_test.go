@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+	t.Setenv("OTHER_KEY", "from-env")
+	r := New("", "")
+
+	v, err := r.Resolve(ctx, "file://"+path)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", v, "the trailing line break is trimmed")
+
+	v, err = r.Resolve(ctx, "env:OTHER_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", v)
+
+	v, err = r.Resolve(ctx, "SG.plain")
+	require.NoError(t, err)
+	assert.Equal(t, "SG.plain", v, "values that aren't references are kept")
+	assert.ElementsMatch(t, []string{"from-file", "from-env"}, r.Values())
+
+	_, err = r.Resolve(ctx, "env:MISSING_KEY")
+	assert.EqualError(t, err, "env:MISSING_KEY: MISSING_KEY is not set")
+	_, err = r.Resolve(ctx, "vault://secret/mmailer#key")
+	assert.EqualError(t, err, "vault://secret/mmailer#key: VAULT_ADDR is not set")
+}
+
+func TestResolve_Vault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/mmailer":
+			_, _ = w.Write([]byte(`{"data": {"data": {"sendgrid": "SG.v2"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/mmailer":
+			_, _ = w.Write([]byte(`{"data": {"sendgrid": "SG.v1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	r := New(srv.URL+"/", "root")
+
+	v, err := r.Resolve(ctx, "vault://secret/data/mmailer#sendgrid")
+	require.NoError(t, err)
+	assert.Equal(t, "SG.v2", v)
+	v, err = r.Resolve(ctx, "vault://kv/mmailer#sendgrid")
+	require.NoError(t, err)
+	assert.Equal(t, "SG.v1", v)
+
+	_, err = r.Resolve(ctx, "vault://kv/mmailer#mailjet")
+	assert.ErrorContains(t, err, "the secret has no field mailjet")
+	_, err = r.Resolve(ctx, "vault://kv/mmailer")
+	assert.ErrorContains(t, err, "must be vault://<path>#<field>")
+	_, err = New(srv.URL, "guest").Resolve(ctx, "vault://kv/mmailer#sendgrid")
+	assert.ErrorContains(t, err, "vault responded 403")
+}
+
+func TestChanged(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte("one"), 0o600))
+	r := New("", "")
+	_, err := r.Resolve(ctx, "file://"+path)
+	require.NoError(t, err)
+
+	changed, err := r.Changed(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	require.NoError(t, os.WriteFile(path, []byte("two"), 0o600))
+	changed, err = r.Changed(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	require.NoError(t, os.Remove(path))
+	_, err = r.Changed(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package smtpd
+
+import (
+	"github.com/emersion/go-sasl"
+)
+
+// loginServer is the server side of the obsolete, but still common, LOGIN mechanism
+// which go-sasl only implements for clients
+type loginServer struct {
+	authenticate func(username, password string) error
+	username     string
+	step         int
+}
+
+func newLoginServer(authenticate func(username, password string) error) sasl.Server {
+	return &loginServer{authenticate: authenticate}
+}
+
+func (l *loginServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch l.step {
+	case 0:
+		l.step++
+		if response == nil {
+			return []byte("Username:"), false, nil
+		}
+		// the client sent the username as initial response
+		fallthrough
+	case 1:
+		l.username = string(response)
+		l.step = 2
+		return []byte("Password:"), false, nil
+	case 2:
+		l.step++
+		return nil, true, l.authenticate(l.username, string(response))
+	default:
+		return nil, false, sasl.ErrUnexpectedClientResponse
+	}
+}
@@ -0,0 +1,216 @@
+package smtpd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/auth"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/smtpx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var received = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "smtp",
+	Name:      "message_count",
+	Help:      "The total number of messages submitted over smtp, by status",
+}, []string{"status"})
+
+// SendFunc sends a submitted email, the context carries the api key the client authenticated with.
+// A returned *mmailer.Error is mapped to the corresponding smtp reply.
+type SendFunc func(ctx context.Context, email mmailer.Email) error
+
+type Options struct {
+	Addr   string
+	Domain string
+	// TLS enables STARTTLS
+	TLS *tls.Config
+	// AllowInsecureAuth allows AUTH without TLS, only for development
+	AllowInsecureAuth bool
+	MaxMessageBytes   int64
+	MaxRecipients     int
+	Timeout           time.Duration
+}
+
+// Server is an smtp submission server. Clients authenticate with AUTH PLAIN or LOGIN, using the
+// name of an api key as username and its secret as password.
+type Server struct {
+	srv  *smtp.Server
+	keys func() auth.Keys
+	send SendFunc
+}
+
+func New(opts Options, keys func() auth.Keys, send SendFunc) *Server {
+	s := &Server{keys: keys, send: send}
+	srv := smtp.NewServer(smtp.BackendFunc(func(c *smtp.Conn) (smtp.Session, error) {
+		return &session{server: s, remote: c.Conn().RemoteAddr().String()}, nil
+	}))
+	srv.Addr = opts.Addr
+	srv.Domain = opts.Domain
+	srv.TLSConfig = opts.TLS
+	srv.AllowInsecureAuth = opts.AllowInsecureAuth
+	srv.MaxMessageBytes = opts.MaxMessageBytes
+	srv.MaxRecipients = opts.MaxRecipients
+	srv.ReadTimeout = opts.Timeout
+	srv.WriteTimeout = opts.Timeout
+	s.srv = srv
+	return s
+}
+
+func (s *Server) ListenAndServe() error {
+	return s.srv.ListenAndServe()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) login(username, password string) (*auth.Key, error) {
+	key, ok := s.keys().Lookup(password)
+	if !ok || key.Name != username {
+		return nil, smtp.ErrAuthFailed
+	}
+	return key, nil
+}
+
+type session struct {
+	server *Server
+	remote string
+	key    *auth.Key
+	rcpts  []string
+}
+
+func (s *session) AuthMechanisms() []string {
+	return []string{sasl.Plain, sasl.Login}
+}
+
+func (s *session) Auth(mech string) (sasl.Server, error) {
+	authenticate := func(username, password string) error {
+		key, err := s.server.login(username, password)
+		if err != nil {
+			logger.Warn("smtp: authentication failed", "remote", s.remote, "username", username)
+			return err
+		}
+		s.key = key
+		return nil
+	}
+	switch mech {
+	case sasl.Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			if identity != "" && identity != username {
+				return smtp.ErrAuthFailed
+			}
+			return authenticate(username, password)
+		}), nil
+	case sasl.Login:
+		return newLoginServer(authenticate), nil
+	default:
+		return nil, smtp.ErrAuthUnknownMechanism
+	}
+}
+
+func (s *session) Mail(from string, opts *smtp.MailOptions) error {
+	if s.key == nil {
+		return smtp.ErrAuthRequired
+	}
+	return nil
+}
+
+func (s *session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if s.key == nil {
+		return smtp.ErrAuthRequired
+	}
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	if s.key == nil {
+		return smtp.ErrAuthRequired
+	}
+	ctx := auth.WithKey(context.Background(), s.key)
+	ctx = logger.AddToLogContext(ctx, "api_key", s.key.Name)
+	ctx = logger.AddToLogContext(ctx, "remote", s.remote)
+
+	if !s.key.Allow() {
+		received.WithLabelValues("rate_limited").Inc()
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 7, 0}, Message: "Rate limit exceeded, try again later"}
+	}
+
+	email, err := smtpx.Parse(r)
+	if err != nil {
+		logger.WarnCtx(ctx, fmt.Sprintf("smtp: could not parse message: %v", err))
+		received.WithLabelValues("invalid").Inc()
+		return &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 6, 0}, Message: "Could not parse message: " + err.Error()}
+	}
+	if !sameRecipients(s.rcpts, smtpx.Recipients(email)) {
+		received.WithLabelValues("invalid").Inc()
+		return &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 5, 1}, Message: "Envelope recipients must match To and Cc, Bcc is not supported"}
+	}
+
+	err = s.server.send(ctx, email)
+	if err != nil {
+		received.WithLabelValues("error").Inc()
+		return replyFor(err)
+	}
+	received.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (s *session) Reset() {
+	s.rcpts = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
+
+func sameRecipients(envelope []string, headers []string) bool {
+	normalize := func(addrs []string) []string {
+		return slicez.Uniq(slicez.Map(addrs, func(a string) string {
+			return strings.ToLower(strings.TrimSpace(a))
+		}))
+	}
+	envelope, headers = normalize(envelope), normalize(headers)
+	if len(envelope) != len(headers) {
+		return false
+	}
+	for _, a := range envelope {
+		if !slicez.Contains(headers, a) {
+			return false
+		}
+	}
+	return true
+}
+
+// replyFor maps the errors of SendFunc to smtp replies, client errors are permanent and the rest temporary
+func replyFor(err error) *smtp.SMTPError {
+	var merr *mmailer.Error
+	if !errors.As(err, &merr) {
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "Could not send message, try again later"}
+	}
+	switch merr.Status {
+	case http.StatusTooManyRequests:
+		return &smtp.SMTPError{Code: 452, EnhancedCode: smtp.EnhancedCode{4, 7, 0}, Message: merr.Message}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: merr.Message}
+	case http.StatusRequestEntityTooLarge:
+		return &smtp.SMTPError{Code: 552, EnhancedCode: smtp.EnhancedCode{5, 3, 4}, Message: merr.Message}
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 6, 0}, Message: merr.Error()}
+	default:
+		return &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: merr.Message}
+	}
+}
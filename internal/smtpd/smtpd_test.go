@@ -0,0 +1,108 @@
+package smtpd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sent struct {
+	mu     sync.Mutex
+	emails []mmailer.Email
+	keys   []string
+}
+
+func start(t *testing.T, send SendFunc) string {
+	key, err := auth.ParseKey("legacy:s3cret")
+	require.NoError(t, err)
+	keys, err := auth.NewKeys(key)
+	require.NoError(t, err)
+
+	s := New(Options{Domain: "localhost", AllowInsecureAuth: true}, func() auth.Keys { return keys }, send)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = s.srv.Serve(l) }()
+	t.Cleanup(func() { _ = s.srv.Close() })
+	return l.Addr().String()
+}
+
+const message = "From: \"Jon\" <jon@example.com>\r\n" +
+	"To: jane@example.com\r\n" +
+	"Cc: bob@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"\r\n" +
+	"Hi there\r\n"
+
+func TestServer_Send(t *testing.T) {
+	got := &sent{}
+	addr := start(t, func(ctx context.Context, email mmailer.Email) error {
+		key, _ := auth.FromContext(ctx)
+		got.mu.Lock()
+		defer got.mu.Unlock()
+		got.emails = append(got.emails, email)
+		got.keys = append(got.keys, key.Name)
+		return nil
+	})
+
+	err := smtp.SendMail(addr, smtp.PlainAuth("", "legacy", "s3cret", "127.0.0.1"),
+		"jon@example.com", []string{"jane@example.com", "bob@example.com"}, []byte(message))
+	require.NoError(t, err)
+
+	got.mu.Lock()
+	defer got.mu.Unlock()
+	require.Len(t, got.emails, 1)
+	assert.Equal(t, "legacy", got.keys[0])
+	assert.Equal(t, mmailer.Address{Name: "Jon", Email: "jon@example.com"}, got.emails[0].From)
+	assert.Equal(t, "Hello", got.emails[0].Subject)
+	assert.Equal(t, "Hi there\r\n", got.emails[0].Text)
+}
+
+func TestServer_Rejects(t *testing.T) {
+	addr := start(t, func(ctx context.Context, email mmailer.Email) error {
+		if email.Subject == "quota" {
+			return &mmailer.Error{Status: http.StatusTooManyRequests, Message: "quota exceeded"}
+		}
+		return nil
+	})
+	plain := smtp.PlainAuth("", "legacy", "s3cret", "127.0.0.1")
+
+	err := smtp.SendMail(addr, smtp.PlainAuth("", "legacy", "wrong", "127.0.0.1"), "jon@example.com", []string{"jane@example.com", "bob@example.com"}, []byte(message))
+	assert.ErrorContains(t, err, "535")
+
+	err = smtp.SendMail(addr, nil, "jon@example.com", []string{"jane@example.com"}, []byte(message))
+	assert.ErrorContains(t, err, "authenticate")
+
+	err = smtp.SendMail(addr, plain, "jon@example.com", []string{"jane@example.com", "bob@example.com", "hidden@example.com"}, []byte(message))
+	assert.ErrorContains(t, err, "Bcc is not supported")
+
+	err = smtp.SendMail(addr, plain, "jon@example.com", []string{"jane@example.com", "bob@example.com"}, []byte(strings.Replace(message, "Hello", "quota", 1)))
+	assert.ErrorContains(t, err, "452")
+}
+
+func TestLoginServer(t *testing.T) {
+	var user, pass string
+	l := newLoginServer(func(username, password string) error {
+		user, pass = username, password
+		return nil
+	})
+	challenge, done, err := l.Next(nil)
+	assert.Equal(t, "Username:", string(challenge))
+	assert.False(t, done)
+	assert.NoError(t, err)
+	challenge, _, _ = l.Next([]byte("legacy"))
+	assert.Equal(t, "Password:", string(challenge))
+	_, done, err = l.Next([]byte("s3cret"))
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", user)
+	assert.Equal(t, "s3cret", pass)
+}
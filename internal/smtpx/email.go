@@ -0,0 +1,82 @@
+package smtpx
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+)
+
+// FromEmail builds the MIME message of an email, the caller sets Message-ID.
+// Text and html are sent as alternatives when both are given, and attachments are
+// encoded from memory.
+func FromEmail(email mmailer.Email) (*Message, error) {
+	message := NewMessage()
+	for k, v := range email.Headers {
+		message.SetHeader(k, v)
+	}
+
+	message.setAddresses("From", []mmailer.Address{email.From})
+	message.setAddresses("To", email.To)
+	message.setAddresses("Cc", email.Cc)
+	message.SetHeader("Subject", email.Subject)
+	message.SetDateHeader("Date", time.Now())
+
+	switch {
+	case len(email.Text) > 0 && len(email.Html) > 0:
+		message.SetBody("text/plain", email.Text)
+		message.AddAlternative("text/html", email.Html)
+	case len(email.Html) > 0:
+		message.SetBody("text/html", email.Html)
+	default:
+		message.SetBody("text/plain", email.Text)
+	}
+
+	for _, a := range email.Attachments {
+		data, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode attachment %s: %w", a.Name, err)
+		}
+		settings := []FileSetting{
+			Rename(a.Name),
+			SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			}),
+		}
+		if a.ContentType != "" {
+			settings = append(settings, SetHeader(map[string][]string{
+				"Content-Type": {a.ContentType + `; name="` + a.Name + `"`},
+			}))
+		}
+		if a.ContentId != "" {
+			settings = append(settings, SetHeader(map[string][]string{
+				"Content-ID": {"<" + a.ContentId + ">"},
+			}))
+			message.Embed(a.Name, settings...)
+			continue
+		}
+		message.Attach(a.Name, settings...)
+	}
+	return message, nil
+}
+
+// setAddresses sets an address header, with names encoded if needed
+func (m *Message) setAddresses(field string, addresses []mmailer.Address) {
+	if len(addresses) == 0 {
+		return
+	}
+	m.header[field] = slicez.Map(addresses, func(a mmailer.Address) string {
+		return m.FormatAddress(a.Email, a.Name)
+	})
+}
+
+// Recipients are the addresses an email is delivered to, to and cc
+func Recipients(email mmailer.Email) []string {
+	return slicez.Map(slicez.Concat(email.To, email.Cc), func(a mmailer.Address) string {
+		return a.Email
+	})
+}
@@ -0,0 +1,48 @@
+package smtpx
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromEmail(t *testing.T) {
+	email := mmailer.Email{
+		Headers: map[string]string{"X-Campaign": "autumn"},
+		From:    mmailer.Address{Name: "Jon Doe", Email: "jon@example.com"},
+		To:      []mmailer.Address{{Email: "jane@example.com"}},
+		Cc:      []mmailer.Address{{Name: "Bob", Email: "bob@example.com"}},
+		Subject: "Hello",
+		Text:    "Hi there",
+		Html:    "<p>Hi there</p>",
+		Attachments: []mmailer.Attachment{
+			{Name: "a.txt", Content: "aGVsbG8=", ContentType: "text/plain"},
+		},
+	}
+	m, err := FromEmail(email)
+	require.NoError(t, err)
+	raw, err := m.Bytes()
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.NoError(t, err)
+	assert.Equal(t, `"Jon Doe" <jon@example.com>`, msg.Header.Get("From"))
+	assert.Equal(t, "jane@example.com", msg.Header.Get("To"))
+	assert.Equal(t, `"Bob" <bob@example.com>`, msg.Header.Get("Cc"))
+	assert.Equal(t, "autumn", msg.Header.Get("X-Campaign"))
+	assert.NotEmpty(t, msg.Header.Get("Date"))
+	assert.Contains(t, msg.Header.Get("Content-Type"), "multipart/mixed")
+	assert.Contains(t, string(raw), "multipart/alternative")
+	assert.Contains(t, string(raw), `filename="a.txt"`)
+	assert.Contains(t, string(raw), "aGVsbG8=")
+
+	assert.Equal(t, []string{"jane@example.com", "bob@example.com"}, Recipients(email))
+
+	email.Attachments[0].Content = "not base64!"
+	_, err = FromEmail(email)
+	assert.Error(t, err)
+}
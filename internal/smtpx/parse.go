@@ -0,0 +1,216 @@
+package smtpx
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/modfin/mmailer"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// structuralHeaders are set from the fields of mmailer.Email, or by the service sending it, and are not kept in Email.Headers
+var structuralHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Subject": true, "Date": true, "Message-Id": true,
+	"Mime-Version": true, "Content-Type": true, "Content-Transfer-Encoding": true, "Content-Disposition": true,
+	"Received": true, "Return-Path": true, "Dkim-Signature": true,
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// Parse reads a MIME message into an email. Text and html parts become the bodies, the first of each kind
+// that isn't an attachment, converted to utf-8. Every other part becomes an attachment, with ContentId set
+// for the inline ones, eg. the images of multipart/related.
+func Parse(r io.Reader) (mmailer.Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return mmailer.Email{}, fmt.Errorf("could not read message: %w", err)
+	}
+
+	email := mmailer.NewEmail()
+	if from, err := parseAddressList(msg.Header, "From"); err != nil || len(from) != 1 {
+		return mmailer.Email{}, errors.New("message must have exactly one from address")
+	} else {
+		email.From = from[0]
+	}
+	email.To, err = parseAddressList(msg.Header, "To")
+	if err != nil {
+		return mmailer.Email{}, err
+	}
+	email.Cc, err = parseAddressList(msg.Header, "Cc")
+	if err != nil {
+		return mmailer.Email{}, err
+	}
+	email.Subject = decodeHeader(msg.Header.Get("Subject"))
+
+	for k, v := range msg.Header {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if structuralHeaders[k] || len(v) == 0 {
+			continue
+		}
+		email.Headers[k] = decodeHeader(v[0])
+	}
+
+	err = parsePart(&email, textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return mmailer.Email{}, err
+	}
+	return email, nil
+}
+
+func parseAddressList(h mail.Header, key string) ([]mmailer.Address, error) {
+	if h.Get(key) == "" {
+		return nil, nil
+	}
+	list, err := h.AddressList(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", key, err)
+	}
+	var res []mmailer.Address
+	for _, a := range list {
+		res = append(res, mmailer.Address{Name: a.Name, Email: a.Address})
+	}
+	return res, nil
+}
+
+func decodeHeader(v string) string {
+	d, err := wordDecoder.DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return d
+}
+
+func parsePart(email *mmailer.Email, h textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			return fmt.Errorf("%s part has no boundary", mediaType)
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("could not read %s part: %w", mediaType, err)
+			}
+			err = parsePart(email, p.Header, p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("could not decode %s part: %w", mediaType, err)
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	isAttachment := disposition == "attachment" || dparams["filename"] != ""
+	switch {
+	case mediaType == "text/plain" && !isAttachment && email.Text == "":
+		email.Text, err = decodeCharset(params["charset"], data)
+		return err
+	case mediaType == "text/html" && !isAttachment && email.Html == "":
+		email.Html, err = decodeCharset(params["charset"], data)
+		return err
+	}
+
+	email.Attachments = append(email.Attachments, mmailer.Attachment{
+		Name:        attachmentName(mediaType, params, dparams),
+		Content:     base64.StdEncoding.EncodeToString(data),
+		ContentType: mediaType,
+		ContentId:   strings.Trim(strings.TrimSpace(h.Get("Content-Id")), "<>"),
+	})
+	return nil
+}
+
+// attachmentName is the filename of the part, its name or one made up from the media type
+func attachmentName(mediaType string, params, dparams map[string]string) string {
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if name != "" {
+		return strings.NewReplacer("\r", "", "\n", "", `"`, "").Replace(decodeHeader(name))
+	}
+	switch exts, _ := mime.ExtensionsByType(mediaType); {
+	case mediaType == "message/rfc822":
+		return "message.eml"
+	case len(exts) > 0:
+		return "attachment" + exts[0]
+	default:
+		return "attachment"
+	}
+}
+
+// decodeCharset converts a text body to utf-8, bodies in unknown charsets are kept as is
+func decodeCharset(charset string, data []byte) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(data), nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(data), nil
+	}
+	res, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("could not decode %s: %w", charset, err)
+	}
+	return string(res), nil
+}
+
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(r), nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// newlineStripper removes line breaks, which the base64 decoder doesn't accept
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		c, err := n.r.Read(p)
+		j := 0
+		for _, b := range p[:c] {
+			if b != '\r' && b != '\n' {
+				p[j] = b
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
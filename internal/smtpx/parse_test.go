@@ -0,0 +1,129 @@
+package smtpx
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_RoundTrip(t *testing.T) {
+	email := mmailer.Email{
+		Headers: map[string]string{"X-Campaign": "autumn"},
+		From:    mmailer.Address{Name: "Jön Doe", Email: "jon@example.com"},
+		To:      []mmailer.Address{{Email: "jane@example.com"}},
+		Cc:      []mmailer.Address{{Name: "Bob", Email: "bob@example.com"}},
+		Subject: "Hellö wörld",
+		Text:    "Hi there, this is a line that is long enough to be wrapped by the quoted printable encoding of the message",
+		Html:    "<p>Hi there</p>",
+		Attachments: []mmailer.Attachment{
+			{Name: "a.txt", Content: "aGVsbG8=", ContentType: "text/plain"},
+		},
+	}
+	m, err := FromEmail(email)
+	require.NoError(t, err)
+	m.SetHeader("Message-ID", "<1@example.com>")
+	raw, err := m.Bytes()
+	require.NoError(t, err)
+
+	parsed, err := Parse(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, email.From, parsed.From)
+	assert.Equal(t, email.To, parsed.To)
+	assert.Equal(t, email.Cc, parsed.Cc)
+	assert.Equal(t, email.Subject, parsed.Subject)
+	assert.Equal(t, email.Text, parsed.Text)
+	assert.Equal(t, email.Html, parsed.Html)
+	assert.Equal(t, email.Attachments, parsed.Attachments)
+	assert.Equal(t, map[string]string{"X-Campaign": "autumn"}, parsed.Headers)
+}
+
+func TestParse_Plain(t *testing.T) {
+	raw := "From: jon@example.com\r\nTo: a@example.com, \"B\" <b@example.com>\r\nSubject: Hi\r\n\r\nJust text\r\n"
+	parsed, err := Parse(strings.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, []mmailer.Address{{Email: "a@example.com"}, {Name: "B", Email: "b@example.com"}}, parsed.To)
+	assert.Equal(t, "Just text\r\n", parsed.Text)
+
+	_, err = Parse(strings.NewReader("To: a@example.com\r\n\r\nNo from"))
+	assert.Error(t, err)
+}
+
+func TestParse_Fixtures(t *testing.T) {
+	tests := []struct {
+		file   string
+		assert func(t *testing.T, email mmailer.Email)
+	}{
+		{"latin1-quoted-printable.eml", func(t *testing.T, email mmailer.Email) {
+			assert.Equal(t, mmailer.Address{Name: "Jörgen Ström", Email: "jorgen@example.com"}, email.From)
+			assert.Equal(t, "Räksmörgås", email.Subject)
+			assert.Equal(t, "Hej Anna, här kommer räksmörgåsen. Det här är en lång rad som bryts av quoted-printable.\r\n", email.Text)
+			assert.Empty(t, email.Html)
+			assert.Empty(t, email.Attachments)
+		}},
+		{"alternative.eml", func(t *testing.T, email mmailer.Email) {
+			assert.Equal(t, []mmailer.Address{{Name: "Jane Doe", Email: "jane@example.com"}, {Email: "bob@example.com"}}, email.To)
+			assert.Equal(t, []mmailer.Address{{Name: "Doe, Carl", Email: "carl@example.com"}}, email.Cc)
+			assert.Equal(t, "Hi, this is the text version 😀", email.Text)
+			assert.Equal(t, "<p>Hi, this is the <b>html</b> version 😀</p>", email.Html)
+			assert.Equal(t, map[string]string{"X-Campaign": "autumn"}, email.Headers)
+		}},
+		{"related.eml", func(t *testing.T, email mmailer.Email) {
+			assert.Equal(t, "See the logo", email.Text)
+			assert.Equal(t, `<p>See the logo – <img src="cid:logo@example.com"></p>`, email.Html)
+			assert.Equal(t, []mmailer.Attachment{
+				{Name: "attachment.png", Content: "iVBORw0KGgo=", ContentType: "image/png", ContentId: "logo@example.com"},
+				{Name: "räkning.pdf", Content: "JVBERi0xLjQK", ContentType: "application/pdf"},
+				{Name: "nötter.txt", Content: "cGVhbnV0cw==", ContentType: "text/plain"},
+			}, email.Attachments)
+		}},
+		{"forwarded.eml", func(t *testing.T, email mmailer.Email) {
+			assert.Equal(t, "Forwarding this", email.Text)
+			require.Len(t, email.Attachments, 1)
+			assert.Equal(t, "message.eml", email.Attachments[0].Name)
+			assert.Equal(t, "message/rfc822", email.Attachments[0].ContentType)
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tc.file))
+			require.NoError(t, err)
+			defer f.Close()
+			email, err := Parse(f)
+			require.NoError(t, err)
+			tc.assert(t, email)
+			assert.NoError(t, email.Validate())
+		})
+	}
+}
+
+func TestParse_Malformed(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "no-boundary.eml"))
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = Parse(f)
+	assert.ErrorContains(t, err, "boundary")
+}
+
+func TestParse_InlineRoundTrip(t *testing.T) {
+	email := mmailer.NewEmail()
+	email.From = mmailer.Address{Email: "jon@example.com"}
+	email.To = []mmailer.Address{{Email: "jane@example.com"}}
+	email.Subject = "Logo"
+	email.Html = `<img src="cid:logo">`
+	email.Attachments = []mmailer.Attachment{{Name: "logo.png", Content: "iVBORw0KGgo=", ContentType: "image/png", ContentId: "logo"}}
+	m, err := FromEmail(email)
+	require.NoError(t, err)
+	raw, err := m.Bytes()
+	require.NoError(t, err)
+
+	parsed, err := Parse(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, email.Html, parsed.Html)
+	assert.Equal(t, email.Attachments, parsed.Attachments)
+}
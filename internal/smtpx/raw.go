@@ -0,0 +1,48 @@
+package smtpx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/modfin/mmailer"
+)
+
+// Envelope reads the headers of a raw message into an email with Raw set. From, To and Cc
+// become the envelope and Subject is kept for logging, the body is left as is.
+func Envelope(raw []byte) (mmailer.Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return mmailer.Email{}, fmt.Errorf("could not read message: %w", err)
+	}
+
+	email := mmailer.NewEmail()
+	email.Headers = nil
+	if from, err := parseAddressList(msg.Header, "From"); err != nil || len(from) != 1 {
+		return mmailer.Email{}, errors.New("message must have exactly one from address")
+	} else {
+		email.From = from[0]
+	}
+	email.To, err = parseAddressList(msg.Header, "To")
+	if err != nil {
+		return mmailer.Email{}, err
+	}
+	email.Cc, err = parseAddressList(msg.Header, "Cc")
+	if err != nil {
+		return mmailer.Email{}, err
+	}
+	email.Subject = decodeHeader(msg.Header.Get("Subject"))
+	email.Raw = raw
+	return email, nil
+}
+
+// MessageId returns the Message-ID of a raw message, without angle brackets, or "" if it has none
+func MessageId(raw []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(msg.Header.Get("Message-Id")), "<>")
+}
@@ -0,0 +1,25 @@
+package smtpx
+
+import (
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	raw := []byte("From: \"Jon\" <jon@example.com>\r\nTo: a@example.com\r\nCc: b@example.com\r\nSubject: =?utf-8?q?H=C3=A4j?=\r\nMessage-ID: <abc@example.com>\r\n\r\nHello\r\n")
+	email, err := Envelope(raw)
+	require.NoError(t, err)
+	assert.Equal(t, mmailer.Address{Name: "Jon", Email: "jon@example.com"}, email.From)
+	assert.Equal(t, []mmailer.Address{{Email: "a@example.com"}}, email.To)
+	assert.Equal(t, []mmailer.Address{{Email: "b@example.com"}}, email.Cc)
+	assert.Equal(t, "Häj", email.Subject)
+	assert.Equal(t, raw, email.Raw)
+	assert.NoError(t, email.Validate())
+	assert.Equal(t, "abc@example.com", MessageId(raw))
+
+	_, err = Envelope([]byte("To: a@example.com\r\n\r\nNo from"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var dropped = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "stream",
+	Name:      "dropped_count",
+	Help:      "The total number of events not delivered to a subscriber since it was too slow",
+})
+
+const (
+	TypePosthook = "posthook"
+	TypeSend     = "send"
+)
+
+// Send is an attempt to send an email through a service
+type Send struct {
+	Service    string   `json:"service"`
+	From       string   `json:"from"`
+	Recipients []string `json:"recipients"`
+	MessageIds []string `json:"message_ids,omitempty"`
+	DurationMs int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Event is either a posthook or a send attempt
+type Event struct {
+	Type     string            `json:"type"`
+	Time     time.Time         `json:"time"`
+	Posthook *mmailer.Posthook `json:"posthook,omitempty"`
+	Send     *Send             `json:"send,omitempty"`
+}
+
+func PosthookEvent(h mmailer.Posthook) Event {
+	return Event{Type: TypePosthook, Time: time.Now(), Posthook: &h}
+}
+
+func SendEvent(s Send) Event {
+	return Event{Type: TypeSend, Time: time.Now(), Send: &s}
+}
+
+// Filter selects events for a subscriber, empty fields matches everything
+type Filter struct {
+	MessageId string
+	Recipient string
+	Service   string
+	// Events are posthook event types, eg. bounce, or "send" for send attempts
+	Events []string
+}
+
+func (f Filter) Match(e Event) bool {
+	var service, event string
+	var recipients, messageIds []string
+	switch {
+	case e.Posthook != nil:
+		service, event = e.Posthook.Service, e.Posthook.Event.String()
+		recipients, messageIds = []string{e.Posthook.Email}, []string{e.Posthook.MessageId}
+	case e.Send != nil:
+		service, event = e.Send.Service, TypeSend
+		recipients, messageIds = e.Send.Recipients, e.Send.MessageIds
+	default:
+		return false
+	}
+
+	if f.Service != "" && !strings.EqualFold(f.Service, service) {
+		return false
+	}
+	if len(f.Events) > 0 && !slicez.Contains(f.Events, event) {
+		return false
+	}
+	if f.MessageId != "" && !slicez.Contains(messageIds, f.MessageId) {
+		return false
+	}
+	if f.Recipient != "" && !slicez.ContainsBy(recipients, func(r string) bool {
+		return strings.EqualFold(r, f.Recipient)
+	}) {
+		return false
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	events chan Event
+}
+
+// Hub fans out published events to subscribers
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: map[*subscriber]struct{}{}}
+}
+
+// Publish never blocks, events are dropped for subscribers that can't keep up
+func (h *Hub) Publish(events ...Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		for _, e := range events {
+			if !s.filter.Match(e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				dropped.Inc()
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel of the events matching filter, buffering up to buffer events.
+// The returned func unsubscribes and closes the channel.
+func (h *Hub) Subscribe(filter Filter, buffer int) (<-chan Event, func()) {
+	s := &subscriber{filter: filter, events: make(chan Event, buffer)}
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, s)
+			h.mu.Unlock()
+			close(s.events)
+		})
+	}
+}
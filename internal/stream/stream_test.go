@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Match(t *testing.T) {
+	bounce := PosthookEvent(mmailer.Posthook{Service: "sendgrid", MessageId: "m1", Email: "a@example.com", Event: mmailer.EventBounce})
+	send := SendEvent(Send{Service: "mailjet", Recipients: []string{"a@example.com", "b@example.com"}, MessageIds: []string{"m2", "m3"}})
+
+	tests := []struct {
+		filter Filter
+		bounce bool
+		send   bool
+	}{
+		{filter: Filter{}, bounce: true, send: true},
+		{filter: Filter{Service: "SendGrid"}, bounce: true},
+		{filter: Filter{Recipient: "B@example.com"}, send: true},
+		{filter: Filter{Recipient: "a@example.com"}, bounce: true, send: true},
+		{filter: Filter{MessageId: "m3"}, send: true},
+		{filter: Filter{Events: []string{"bounce", "spam"}}, bounce: true},
+		{filter: Filter{Events: []string{"send"}}, send: true},
+		{filter: Filter{Events: []string{"send"}, Service: "sendgrid"}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.bounce, tc.filter.Match(bounce), "%+v", tc.filter)
+		assert.Equal(t, tc.send, tc.filter.Match(send), "%+v", tc.filter)
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	all, unsubAll := h.Subscribe(Filter{}, 1)
+	bounces, unsubBounces := h.Subscribe(Filter{Events: []string{"bounce"}}, 10)
+
+	h.Publish(
+		PosthookEvent(mmailer.Posthook{Event: mmailer.EventOpen}),
+		PosthookEvent(mmailer.Posthook{Event: mmailer.EventBounce}),
+	)
+
+	// The buffer of all only fits one event, the second is dropped rather than blocking the publisher
+	assert.Equal(t, mmailer.EventOpen, (<-all).Posthook.Event)
+	assert.Len(t, all, 0)
+	assert.Equal(t, mmailer.EventBounce, (<-bounces).Posthook.Event)
+
+	unsubAll()
+	unsubAll()
+	_, open := <-all
+	assert.False(t, open)
+
+	h.Publish(PosthookEvent(mmailer.Posthook{Event: mmailer.EventBounce}))
+	assert.Len(t, bounces, 1)
+	unsubBounces()
+}
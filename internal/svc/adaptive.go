@@ -0,0 +1,181 @@
+package svc
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var adaptiveWeight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "mmailer",
+	Subsystem: "service",
+	Name:      "adaptive_weight",
+	Help:      "The share of the traffic given to the service by the adaptive select strategy, the last time it was a candidate",
+}, []string{"name"})
+
+// latencySamples is the number of latest send durations the p95 latency is computed from
+const latencySamples = 100
+
+// health is the rolling success rate and latency of the sends of each service, observed by WithMetric
+var health = &healthRegistry{alpha: AdaptiveOptions{}.withDefaults().Alpha, services: map[string]*serviceHealth{}}
+
+type healthRegistry struct {
+	mu       sync.Mutex
+	alpha    float64
+	services map[string]*serviceHealth
+}
+
+type serviceHealth struct {
+	success   float64
+	latencies []time.Duration
+	next      int
+	p95       time.Duration
+}
+
+func (h *healthRegistry) setAlpha(alpha float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.alpha = alpha
+}
+
+func (h *healthRegistry) observe(name string, d time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.services[name]
+	if !ok {
+		s = &serviceHealth{success: 1}
+		h.services[name] = s
+	}
+	var v float64
+	if err == nil {
+		v = 1
+	}
+	s.success = h.alpha*v + (1-h.alpha)*s.success
+
+	if len(s.latencies) < latencySamples {
+		s.latencies = append(s.latencies, d)
+	} else {
+		s.latencies[s.next] = d
+		s.next = (s.next + 1) % latencySamples
+	}
+	sorted := slices.Clone(s.latencies)
+	slices.Sort(sorted)
+	s.p95 = sorted[len(sorted)*95/100]
+}
+
+// get returns the success rate and p95 latency of a service, a service without sends is considered healthy
+func (h *healthRegistry) get(name string) (success float64, p95 time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.services[name]
+	if !ok {
+		return 1, 0
+	}
+	return s.success, s.p95
+}
+
+type AdaptiveOptions struct {
+	// MinShare is the least share of the traffic any service gets, which is what lets a degraded service recover
+	MinShare float64
+	// MaxShare is the largest share of the traffic a service may get
+	MaxShare float64
+	// LatencyTarget is the p95 latency above which a service is scored down, in proportion
+	LatencyTarget time.Duration
+	// Alpha is the weight of the latest send in the success rate, which is an exponentially weighted moving average
+	Alpha float64
+}
+
+func (o AdaptiveOptions) withDefaults() AdaptiveOptions {
+	if o.MaxShare <= 0 || o.MaxShare > 1 {
+		o.MaxShare = 1
+	}
+	if o.MinShare < 0 || o.MinShare > o.MaxShare {
+		o.MinShare = 0
+	}
+	if o.LatencyTarget <= 0 {
+		o.LatencyTarget = 2 * time.Second
+	}
+	if o.Alpha <= 0 || o.Alpha > 1 {
+		o.Alpha = 0.05
+	}
+	return o
+}
+
+// SelectAdaptive shifts traffic away from services with failing or slow sends, as observed by WithMetric.
+// Each service is scored by its success rate squared, scaled down by how much its p95 latency exceeds the target,
+// and gets its share of the score, bounded by the min and max shares.
+func SelectAdaptive(opts AdaptiveOptions) mmailer.SelectStrategy {
+	opts = opts.withDefaults()
+	health.setAlpha(opts.Alpha)
+	return func(services []mmailer.Service) mmailer.Service {
+		shares := adaptiveShares(services, opts)
+		r := rand.Float64()
+		for i, s := range services {
+			r -= shares[i]
+			if r <= 0 {
+				return s
+			}
+		}
+		return services[len(services)-1]
+	}
+}
+
+func adaptiveShares(services []mmailer.Service, opts AdaptiveOptions) []float64 {
+	scores := make([]float64, len(services))
+	for i, s := range services {
+		success, p95 := health.get(s.Name())
+		score := success * success
+		if p95 > opts.LatencyTarget {
+			score *= float64(opts.LatencyTarget) / float64(p95)
+		}
+		scores[i] = max(score, 1e-6)
+	}
+	shares := bound(scores, opts.MinShare, opts.MaxShare)
+	for i, s := range services {
+		adaptiveWeight.WithLabelValues(s.Name()).Set(shares[i])
+	}
+	return shares
+}
+
+// bound normalizes the scores into shares between min and max, that sums to 1. The shares over or under the
+// bounds are fixed at them, and the rest is shared by the others in proportion to their scores.
+func bound(scores []float64, minShare, maxShare float64) []float64 {
+	n := float64(len(scores))
+	minShare = min(minShare, 1/n)
+	maxShare = max(maxShare, 1/n)
+
+	shares := make([]float64, len(scores))
+	fixed := make([]bool, len(scores))
+	for range scores {
+		var free, sum float64 = 1, 0
+		for i, s := range scores {
+			if fixed[i] {
+				free -= shares[i]
+			} else {
+				sum += s
+			}
+		}
+		changed := false
+		for i, s := range scores {
+			if fixed[i] {
+				continue
+			}
+			shares[i] = free * s / sum
+			switch {
+			case shares[i] < minShare:
+				shares[i], fixed[i], changed = minShare, true, true
+			case shares[i] > maxShare:
+				shares[i], fixed[i], changed = maxShare, true, true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return shares
+}
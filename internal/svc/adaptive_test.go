@@ -0,0 +1,63 @@
+package svc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBound(t *testing.T) {
+	tests := []struct {
+		name     string
+		scores   []float64
+		min, max float64
+		shares   []float64
+	}{
+		{"proportional", []float64{1, 1, 2}, 0, 1, []float64{0.25, 0.25, 0.5}},
+		{"min share", []float64{1, 0.01}, 0.1, 1, []float64{0.9, 0.1}},
+		{"max share", []float64{1, 8, 1}, 0, 0.5, []float64{0.25, 0.5, 0.25}},
+		{"bounds too tight", []float64{1, 1}, 0.6, 0.4, []float64{0.5, 0.5}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			shares := bound(tc.scores, tc.min, tc.max)
+			assert.InDeltaSlice(t, tc.shares, shares, 1e-9)
+		})
+	}
+}
+
+func TestSelectAdaptive(t *testing.T) {
+	healthy, failing, slow := &TestService{"adaptive-healthy"}, &TestService{"adaptive-failing"}, &TestService{"adaptive-slow"}
+	services := []mmailer.Service{healthy, failing, slow}
+	opts := AdaptiveOptions{MinShare: 0.05, LatencyTarget: time.Second, Alpha: 0.2}
+	selects := SelectAdaptive(opts)
+
+	shares := adaptiveShares(services, opts.withDefaults())
+	assert.InDeltaSlice(t, []float64{1. / 3, 1. / 3, 1. / 3}, shares, 1e-9, "unobserved services share evenly")
+
+	for i := 0; i < 50; i++ {
+		health.observe(healthy.Name(), 100*time.Millisecond, nil)
+		health.observe(failing.Name(), 100*time.Millisecond, errors.New("boom"))
+		health.observe(slow.Name(), 4*time.Second, nil)
+	}
+	shares = adaptiveShares(services, opts.withDefaults())
+	assert.InDelta(t, 0.05, shares[1], 1e-9, "a failing service keeps the min share")
+	assert.InDelta(t, 0.76, shares[0], 1e-9)
+	assert.InDelta(t, 0.19, shares[2], 1e-9, "the slow service is scored by target/p95")
+
+	count := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		count[selects(services).Name()]++
+	}
+	assert.InDelta(t, 7600, count[healthy.Name()], 300)
+	assert.InDelta(t, 500, count[failing.Name()], 150)
+
+	for i := 0; i < 50; i++ {
+		health.observe(failing.Name(), 100*time.Millisecond, nil)
+	}
+	shares = adaptiveShares(services, opts.withDefaults())
+	assert.Greater(t, shares[1], 0.4, "a recovered service gets its share back")
+}
@@ -0,0 +1,48 @@
+package svc
+
+import (
+	"context"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/modfin/mmailer/services"
+)
+
+type dryRunKey struct{}
+
+// DryRun marks the context so services decorated WithDryRun captures the email instead of sending it
+func DryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func IsDryRun(ctx context.Context) bool {
+	dry, _ := ctx.Value(dryRunKey{}).(bool)
+	return dry
+}
+
+// WithDryRun captures emails in box, as sent by the service, when the context is marked by DryRun
+func WithDryRun(service mmailer.Service, box *mailbox.Mailbox) mmailer.Service {
+	return &dryRunService{
+		Service: service,
+		box:     box,
+	}
+}
+
+type dryRunService struct {
+	mmailer.Service
+	box *mailbox.Mailbox
+}
+
+func (d *dryRunService) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	if IsDryRun(ctx) && email.Individual {
+		return services.SendIndividually(ctx, email, d.Send)
+	}
+	if IsDryRun(ctx) {
+		return d.box.Capture(d.Name(), email)
+	}
+	return d.Service.Send(ctx, email)
+}
+
+func (d *dryRunService) Unwrap() mmailer.Service {
+	return d.Service
+}
@@ -0,0 +1,45 @@
+package svc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDryRun(t *testing.T) {
+	m := &MockService{}
+	m.On("Send", mock.Anything, mock.Anything).Return([]mmailer.Response{{MessageId: "sent"}}, nil).Once()
+
+	box := mailbox.New(10)
+	s := WithDryRun(m, box)
+	email := mmailer.Email{
+		From:    mmailer.Address{Email: "jon@example.com"},
+		To:      []mmailer.Address{{Email: "jane@example.com"}},
+		Subject: "Hello",
+		Text:    "Hi",
+		ServiceConfig: []mmailer.ConfigItem{
+			{Service: "mock service", Key: mmailer.IpPool, Value: "eu"},
+			{Service: "other", Key: mmailer.IpPool, Value: "us"},
+			{Key: mmailer.DisableTracking, Value: "true"},
+		},
+	}
+
+	res, err := s.Send(DryRun(context.Background()), email)
+	require.NoError(t, err)
+	assert.Equal(t, "mock service", res[0].Service)
+	require.Len(t, box.List(""), 1)
+	assert.Equal(t, "mock service", box.List("")[0].Service)
+	assert.Equal(t, []mmailer.ConfigItem{email.ServiceConfig[0], email.ServiceConfig[2]}, box.List("")[0].ServiceConfig,
+		"the settings the service would have been given are recorded")
+
+	res, err = s.Send(context.Background(), email)
+	require.NoError(t, err)
+	assert.Equal(t, "sent", res[0].MessageId)
+	assert.Len(t, box.List(""), 1)
+	m.AssertExpectations(t)
+}
@@ -50,3 +50,7 @@ func (a *allowListFilter) Send(ctx context.Context, email mmailer.Email) (res []
 
 	return a.Service.Send(ctx, email)
 }
+
+func (a *allowListFilter) Unwrap() mmailer.Service {
+	return a.Service
+}
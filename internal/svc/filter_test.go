@@ -17,6 +17,10 @@ func (m *MockService) Name() string {
 	return "mock service"
 }
 
+func (m *MockService) CanSend(email mmailer.Email) bool {
+	return true
+}
+
 func (m *MockService) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 	args := m.Called(body)
 	return args.Get(0).([]mmailer.Posthook), args.Error(1)
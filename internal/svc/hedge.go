@@ -0,0 +1,153 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var hedgeSend = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "hedge",
+	Name:      "send_count",
+	Help:      "The total number of hedged emails, by the attempt that won, primary, hedge or none",
+}, []string{"winner"})
+
+type hedgeAttempt struct {
+	service string
+	hedge   bool
+	// email is what was sent, the hedge is only sent to the recipients the primary failed for
+	email mmailer.Email
+	res   []mmailer.Response
+	err   error
+}
+
+// RetryHedged sends emails with the mmailer.Hedge config item by a second service as well, if the first has not
+// responded within the threshold, or has failed. The first attempt to succeed wins and the other is canceled,
+// the response has the outcome of both. Since both may have been sent, it is only done when asked for, other
+// emails are sent with retry. An item for a service only hedges the emails that service is selected for.
+func RetryHedged(threshold time.Duration, retry mmailer.RetryStrategy) mmailer.RetryStrategy {
+	return func(ctx context.Context, s mmailer.Service, e mmailer.Email, services []mmailer.Service) ([]mmailer.Response, error) {
+		item, ok := slicez.Find(e.ServiceConfig, func(c mmailer.ConfigItem) bool {
+			return c.Key == mmailer.Hedge && (c.Service == "" || strings.EqualFold(c.Service, s.Name()))
+		})
+		if !ok {
+			return retry(ctx, s, e, services)
+		}
+		other, ok := slicez.Find(services, func(ss mmailer.Service) bool {
+			return ss.Name() != s.Name()
+		})
+		if !ok {
+			return retry(ctx, s, e, services)
+		}
+		wait := threshold
+		if item.Value != "" {
+			d, err := time.ParseDuration(item.Value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("%s must be a duration, eg. 500ms, got %q", mmailer.Hedge, item.Value)
+			}
+			wait = d
+		}
+		return hedge(ctx, s, other, e, wait)
+	}
+}
+
+func hedge(ctx context.Context, primary, other mmailer.Service, e mmailer.Email, threshold time.Duration) ([]mmailer.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered, so the attempt that is canceled doesn't block when it returns
+	attempts := make(chan hedgeAttempt, 2)
+	start := func(s mmailer.Service, hedge bool, e mmailer.Email) {
+		ctx := logger.AddToLogContext(ctx, "service", s.Name())
+		go func() {
+			res, err := mmailer.SendAttempt(ctx, s, e)
+			attempts <- hedgeAttempt{service: s.Name(), hedge: hedge, email: e, res: res, err: err}
+		}()
+	}
+	startHedge := func(reason string, e mmailer.Email) {
+		logger.WarnCtx(logger.AddToLogContext(ctx, "hedge_service", other.Name()), reason)
+		start(other, true, e)
+	}
+
+	start(primary, false, e)
+	timer := time.NewTimer(threshold)
+	defer timer.Stop()
+
+	var done []hedgeAttempt
+	hedged := false
+	for running := 1; running > 0; {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged, running = true, running+1
+				startHedge(fmt.Sprintf("no response within %v, hedging the send", threshold), e)
+			}
+		case a := <-attempts:
+			running--
+			done = append(done, a)
+			if a.err == nil {
+				running = 0
+				break
+			}
+			if !hedged {
+				// the hedge is only sent to the recipients the primary failed for
+				remaining, _ := mmailer.Remaining(e, a.err)
+				hedged, running = true, running+1
+				startHedge(fmt.Sprintf("err sending mail, hedging the send: %v", a.err), remaining)
+			}
+		}
+	}
+
+	if !hedged {
+		return done[0].res, done[0].err
+	}
+
+	var res, sent []mmailer.Response
+	var errs []error
+	for _, a := range done {
+		if a.err != nil {
+			_, s := mmailer.Remaining(a.email, a.err)
+			sent = append(sent, s...)
+			res = append(res, s...)
+			res = append(res, mmailer.Response{Service: a.service, Hedge: mmailer.HedgeFailed})
+			errs = append(errs, fmt.Errorf("%s: %w", a.service, a.err))
+			continue
+		}
+		res = append(res, slicez.Map(a.res, func(r mmailer.Response) mmailer.Response {
+			r.Hedge = mmailer.HedgeWon
+			return r
+		})...)
+	}
+	if len(done) == 1 {
+		canceled := other.Name()
+		if done[0].hedge {
+			canceled = primary.Name()
+		}
+		res = append(res, mmailer.Response{Service: canceled, Hedge: mmailer.HedgeCanceled})
+	}
+
+	last := done[len(done)-1]
+	switch {
+	case last.err != nil:
+		hedgeSend.WithLabelValues("none").Inc()
+		if len(sent) > 0 {
+			failed, _ := mmailer.Remaining(last.email, last.err)
+			return sent, &mmailer.PartialError{Sent: sent, Failed: failed.To, Err: errors.Join(errs...)}
+		}
+		return nil, errors.Join(errs...)
+	case last.hedge:
+		hedgeSend.WithLabelValues("hedge").Inc()
+	default:
+		hedgeSend.WithLabelValues("primary").Inc()
+	}
+	return res, nil
+}
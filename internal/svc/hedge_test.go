@@ -0,0 +1,101 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type slowService struct {
+	TestService
+	delay    time.Duration
+	err      error
+	canceled chan struct{}
+}
+
+func (s *slowService) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	select {
+	case <-time.After(s.delay):
+		if s.err != nil {
+			return nil, s.err
+		}
+		return []mmailer.Response{{Service: s.name, MessageId: s.name + "-1"}}, nil
+	case <-ctx.Done():
+		close(s.canceled)
+		return nil, ctx.Err()
+	}
+}
+
+func newSlow(name string, delay time.Duration, err error) *slowService {
+	return &slowService{TestService: TestService{name}, delay: delay, err: err, canceled: make(chan struct{})}
+}
+
+func hedged(threshold string) mmailer.Email {
+	return mmailer.Email{ServiceConfig: []mmailer.ConfigItem{{Key: mmailer.Hedge, Value: threshold}}}
+}
+
+func TestRetryHedged(t *testing.T) {
+	retry := RetryHedged(time.Hour, mmailer.RetryNone)
+
+	t.Run("hedge wins", func(t *testing.T) {
+		primary, other := newSlow("primary", time.Hour, nil), newSlow("other", 0, nil)
+		res, err := retry(context.Background(), primary, hedged("10ms"), []mmailer.Service{primary, other})
+		require.NoError(t, err)
+		assert.Equal(t, []mmailer.Response{
+			{Service: "other", MessageId: "other-1", Hedge: mmailer.HedgeWon},
+			{Service: "primary", Hedge: mmailer.HedgeCanceled},
+		}, res)
+		select {
+		case <-primary.canceled:
+		case <-time.After(time.Second):
+			t.Fatal("primary was not canceled")
+		}
+	})
+
+	t.Run("primary fails", func(t *testing.T) {
+		primary, other := newSlow("primary", 0, errors.New("boom")), newSlow("other", 0, nil)
+		res, err := retry(context.Background(), primary, hedged(""), []mmailer.Service{primary, other})
+		require.NoError(t, err, "a failed primary is hedged at once")
+		assert.Equal(t, []mmailer.Response{
+			{Service: "primary", Hedge: mmailer.HedgeFailed},
+			{Service: "other", MessageId: "other-1", Hedge: mmailer.HedgeWon},
+		}, res)
+	})
+
+	t.Run("both fail", func(t *testing.T) {
+		primary, other := newSlow("primary", 0, errors.New("boom")), newSlow("other", 0, errors.New("bang"))
+		_, err := retry(context.Background(), primary, hedged(""), []mmailer.Service{primary, other})
+		assert.ErrorContains(t, err, "primary: boom")
+		assert.ErrorContains(t, err, "other: bang")
+	})
+
+	t.Run("within threshold", func(t *testing.T) {
+		primary, other := newSlow("primary", 0, nil), newSlow("other", 0, nil)
+		res, err := retry(context.Background(), primary, hedged("1m"), []mmailer.Service{primary, other})
+		require.NoError(t, err)
+		assert.Equal(t, []mmailer.Response{{Service: "primary", MessageId: "primary-1"}}, res, "not hedged")
+	})
+
+	t.Run("not asked for", func(t *testing.T) {
+		primary, other := newSlow("primary", 0, errors.New("boom")), newSlow("other", 0, nil)
+		_, err := retry(context.Background(), primary, mmailer.Email{}, []mmailer.Service{primary, other})
+		assert.EqualError(t, err, "boom", "sent by the other retry strategy")
+	})
+
+	t.Run("asked for another service", func(t *testing.T) {
+		primary, other := newSlow("primary", 0, errors.New("boom")), newSlow("other", 0, nil)
+		e := hedged("10ms")
+		e.ServiceConfig[0].Service = "other"
+		_, err := retry(context.Background(), primary, e, []mmailer.Service{primary, other})
+		assert.EqualError(t, err, "boom", "only emails sent by other are hedged")
+
+		e.ServiceConfig[0].Service = "Primary"
+		_, err = retry(context.Background(), primary, e, []mmailer.Service{primary, other})
+		assert.NoError(t, err)
+	})
+}
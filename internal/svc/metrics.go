@@ -41,7 +41,7 @@ func (m *metricService) Send(ctx context.Context, email mmailer.Email) (res []mm
 	name := m.Name()
 	timer := prometheus.NewTimer(mailSendTime.WithLabelValues(name))
 	defer func() {
-		timer.ObserveDuration()
+		health.observe(name, timer.ObserveDuration(), err)
 		if err == nil {
 			mailSend.WithLabelValues(name, "success").Inc()
 			return
@@ -62,3 +62,7 @@ func (m *metricService) UnmarshalPosthook(body []byte) (p []mmailer.Posthook, er
 
 	return m.Service.UnmarshalPosthook(body)
 }
+
+func (m *metricService) Unwrap() mmailer.Service {
+	return m.Service
+}
@@ -0,0 +1,47 @@
+package svc
+
+import (
+	"context"
+	"time"
+
+	"github.com/modfin/mmailer"
+)
+
+// Attempt is the outcome of one call to Send of a service
+type Attempt struct {
+	Service   string
+	Email     mmailer.Email
+	Responses []mmailer.Response
+	Err       error
+	Duration  time.Duration
+}
+
+// WithObserver calls observe after every send attempt of the service, whether it succeeded or not
+func WithObserver(service mmailer.Service, observe func(ctx context.Context, a Attempt)) mmailer.Service {
+	return &observedService{
+		Service: service,
+		observe: observe,
+	}
+}
+
+type observedService struct {
+	mmailer.Service
+	observe func(ctx context.Context, a Attempt)
+}
+
+func (o *observedService) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	start := time.Now()
+	res, err := o.Service.Send(ctx, email)
+	o.observe(ctx, Attempt{
+		Service:   o.Name(),
+		Email:     email,
+		Responses: res,
+		Err:       err,
+		Duration:  time.Since(start),
+	})
+	return res, err
+}
+
+func (o *observedService) Unwrap() mmailer.Service {
+	return o.Service
+}
@@ -0,0 +1,32 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWithObserver(t *testing.T) {
+	m := &MockService{}
+	m.On("Send", mock.Anything, mock.Anything).Return([]mmailer.Response{{MessageId: "1"}}, nil).Once()
+	m.On("Send", mock.Anything, mock.Anything).Return([]mmailer.Response{}, errors.New("boom")).Once()
+
+	var attempts []Attempt
+	s := WithObserver(m, func(ctx context.Context, a Attempt) {
+		attempts = append(attempts, a)
+	})
+
+	_, _ = s.Send(context.Background(), mmailer.Email{Subject: "first"})
+	_, _ = s.Send(context.Background(), mmailer.Email{Subject: "second"})
+
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "mock service", attempts[0].Service)
+	assert.Equal(t, "first", attempts[0].Email.Subject)
+	assert.Equal(t, "1", attempts[0].Responses[0].MessageId)
+	assert.NoError(t, attempts[0].Err)
+	assert.EqualError(t, attempts[1].Err, "boom")
+}
@@ -3,33 +3,65 @@ package svc
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/modfin/mmailer"
 	"github.com/modfin/mmailer/internal/logger"
 )
 
+// partialSend keeps the responses of the recipients an individual email has been sent to, so that retries only
+// send it again to the recipients that failed
+type partialSend struct {
+	sent []mmailer.Response
+}
+
+// attempt sends e by s, if it is partially sent e is narrowed down to the recipients that failed
+func (p *partialSend) attempt(ctx context.Context, s mmailer.Service, e *mmailer.Email) ([]mmailer.Response, error) {
+	res, err := mmailer.SendAttempt(ctx, s, *e)
+	if err == nil {
+		return append(p.sent, res...), nil
+	}
+	var sent []mmailer.Response
+	*e, sent = mmailer.Remaining(*e, err)
+	p.sent = append(p.sent, sent...)
+	return nil, err
+}
+
+// failed returns err, as a *mmailer.PartialError with the responses so far if some recipients were sent to
+func (p *partialSend) failed(e mmailer.Email, err error) ([]mmailer.Response, error) {
+	if len(p.sent) == 0 {
+		return nil, err
+	}
+	return p.sent, &mmailer.PartialError{Sent: p.sent, Failed: e.To, Err: err}
+}
+
 func RetryEach(ctx context.Context, s mmailer.Service, e mmailer.Email, services []mmailer.Service) (res []mmailer.Response, err error) {
-	res, err = s.Send(ctx, e)
+	var p partialSend
+	res, err = p.attempt(ctx, s, &e)
 	if err == nil {
 		return res, nil
 	}
 
-	var errs []error
+	errs := []error{fmt.Errorf("%s: %w", s.Name(), err)}
 	for _, ss := range services {
+		if s.Name() == ss.Name() {
+			continue
+		}
 		ctx := logger.AddToLogContext(ctx, "fallback_service", ss.Name())
 		logger.WarnCtx(ctx, "err sending mail, retrying with fallback", "error", err)
 		ctx = logger.AddToLogContext(ctx, "service", ss.Name())
-		res, err = ss.Send(ctx, e)
+		res, err = p.attempt(ctx, ss, &e)
 		if err == nil {
 			return res, nil
 		}
-		errs = append(errs, err)
+		errs = append(errs, fmt.Errorf("%s: %w", ss.Name(), err))
 	}
-	return nil, errors.Join(err)
+	return p.failed(e, errors.Join(errs...))
 }
 
 func RetryOneOther(ctx context.Context, s mmailer.Service, e mmailer.Email, services []mmailer.Service) (res []mmailer.Response, err error) {
-	res, err = s.Send(ctx, e)
+	var p partialSend
+	res, err = p.attempt(ctx, s, &e)
 	if err == nil {
 		return res, nil
 	}
@@ -40,15 +72,24 @@ func RetryOneOther(ctx context.Context, s mmailer.Service, e mmailer.Email, serv
 		ctx := logger.AddToLogContext(ctx, "fallback_service", ss.Name())
 		logger.WarnCtx(ctx, "err sending mail, retrying with fallback", "error", err)
 		ctx = logger.AddToLogContext(ctx, "service", ss.Name())
-		return ss.Send(ctx, e)
+		res, err = p.attempt(ctx, ss, &e)
+		if err == nil {
+			return res, nil
+		}
+		return p.failed(e, err)
 	}
-	return nil, err
+	return p.failed(e, err)
 }
 
 func RetrySame(ctx context.Context, s mmailer.Service, e mmailer.Email, services []mmailer.Service) (res []mmailer.Response, err error) {
-	res, err = s.Send(ctx, e)
+	var p partialSend
+	res, err = p.attempt(ctx, s, &e)
+	if err == nil {
+		return res, nil
+	}
+	res, err = p.attempt(ctx, s, &e)
 	if err == nil {
 		return res, nil
 	}
-	return s.Send(ctx, e)
+	return p.failed(e, err)
 }
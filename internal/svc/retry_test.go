@@ -0,0 +1,116 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func attempted(r *mmailer.SendReport) []string {
+	return slicez.Map(r.Attempts(), func(a mmailer.Attempt) string {
+		return a.Service + ":" + string(a.Class)
+	})
+}
+
+func TestRetryEach(t *testing.T) {
+	a, b, c := newSlow("a", 0, errors.New("boom")), newSlow("b", 0, errors.New("bang")), newSlow("c", 0, nil)
+
+	ctx, report := mmailer.WithReport(context.Background())
+	res, err := RetryEach(ctx, a, mmailer.Email{}, []mmailer.Service{a, b, c})
+	require.NoError(t, err)
+	assert.Equal(t, "c", res[0].Service)
+	assert.Equal(t, []string{"a:service", "b:service", "c:"}, attempted(report), "the primary is not retried")
+
+	ctx, report = mmailer.WithReport(context.Background())
+	_, err = RetryEach(ctx, a, mmailer.Email{}, []mmailer.Service{a, b})
+	assert.ErrorContains(t, err, "a: boom")
+	assert.ErrorContains(t, err, "b: bang")
+	assert.Equal(t, []string{"a:service", "b:service"}, attempted(report))
+}
+
+func TestRetryOneOther(t *testing.T) {
+	a, b, c := newSlow("a", 0, errors.New("boom")), newSlow("b", 0, errors.New("bang")), newSlow("c", 0, nil)
+
+	ctx, report := mmailer.WithReport(context.Background())
+	_, err := RetryOneOther(ctx, a, mmailer.Email{}, []mmailer.Service{a, b, c})
+	assert.EqualError(t, err, "bang")
+	assert.Equal(t, []string{"a:service", "b:service"}, attempted(report))
+}
+
+func TestFacade_SendReport(t *testing.T) {
+	a, b := newSlow("a", 0, context.DeadlineExceeded), newSlow("b", 0, nil)
+	f := mmailer.New(func(s []mmailer.Service) mmailer.Service { return s[0] }, RetryOneOther, a, b)
+
+	ctx, report := mmailer.WithReport(context.Background())
+	_, err := f.Send(ctx, mmailer.Email{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:timeout", "b:"}, attempted(report))
+}
+
+// individualService sends one message per recipient, and fails for the rejected ones
+type individualService struct {
+	TestService
+	rejected string
+	sent     []string
+}
+
+func (s *individualService) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	return services.SendIndividually(ctx, email, func(ctx context.Context, e mmailer.Email) ([]mmailer.Response, error) {
+		to := e.To[0].Email
+		s.sent = append(s.sent, to)
+		if to == s.rejected {
+			return nil, errors.New("rejected")
+		}
+		return []mmailer.Response{{Service: s.name, MessageId: s.name + "-" + to}}, nil
+	})
+}
+
+func TestRetry_PartiallySent(t *testing.T) {
+	email := mmailer.Email{
+		To:         []mmailer.Address{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}},
+		Individual: true,
+	}
+
+	primary := &individualService{TestService: TestService{"primary"}, rejected: "b@example.com"}
+	fallback := &individualService{TestService: TestService{"fallback"}}
+	res, err := RetryOneOther(context.Background(), primary, email, []mmailer.Service{primary, fallback})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a@example.com", "b@example.com", "c@example.com"}, primary.sent)
+	assert.Equal(t, []string{"b@example.com"}, fallback.sent, "only the recipient that failed is sent to again")
+	assert.Equal(t, []string{"primary-a@example.com", "primary-c@example.com", "fallback-b@example.com"},
+		slicez.Map(res, func(r mmailer.Response) string { return r.MessageId }))
+
+	primary = &individualService{TestService: TestService{"primary"}, rejected: "b@example.com"}
+	fallback = &individualService{TestService: TestService{"fallback"}, rejected: "b@example.com"}
+	res, err = RetryEach(context.Background(), primary, email, []mmailer.Service{primary, fallback})
+	var perr *mmailer.PartialError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, []mmailer.Address{{Email: "b@example.com"}}, perr.Failed)
+	assert.Equal(t, res, perr.Sent)
+	assert.Len(t, res, 2)
+	assert.Equal(t, []string{"b@example.com"}, fallback.sent)
+}
+
+func TestRetryHedged_PartiallySent(t *testing.T) {
+	email := hedged("1h")
+	email.To = []mmailer.Address{{Email: "a@example.com"}, {Email: "b@example.com"}}
+	email.Individual = true
+
+	primary := &individualService{TestService: TestService{"primary"}, rejected: "b@example.com"}
+	other := &individualService{TestService: TestService{"other"}}
+	res, err := RetryHedged(time.Hour, mmailer.RetryNone)(context.Background(), primary, email, []mmailer.Service{primary, other})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b@example.com"}, other.sent, "the hedge is only sent to the recipient that failed")
+	assert.Equal(t, []mmailer.Response{
+		{Service: "primary", MessageId: "primary-a@example.com", Email: "a@example.com"},
+		{Service: "primary", Hedge: mmailer.HedgeFailed},
+		{Service: "other", MessageId: "other-b@example.com", Email: "b@example.com", Hedge: mmailer.HedgeWon},
+	}, res)
+}
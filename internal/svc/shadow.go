@@ -0,0 +1,110 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var shadowSent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "shadow",
+	Name:      "send_count",
+	Help:      "The total number of emails sent by services with a shadow and by the shadows, by service, role and status",
+}, []string{"service", "role", "status"})
+
+var shadowDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "mmailer",
+	Subsystem: "shadow",
+	Name:      "send_duration_seconds",
+	Help:      "The duration of sends by services with a shadow and by the shadows, by service and role",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"service", "role"})
+
+type ShadowOptions struct {
+	// Percent of the emails sent by the primary that are mirrored to the shadow, 0 to 100
+	Percent float64
+	// Seeds replace the recipients of the mirrored emails, if empty the real recipients get them,
+	// which is only for shadow services that never deliver, eg. the sandbox
+	Seeds []mmailer.Address
+	// Timeout of a mirrored send
+	Timeout time.Duration
+}
+
+// WithShadow mirrors a share of the emails successfully sent by service to shadow, eg. a vendor being evaluated.
+// The mirrored send is asynchronous and never affects the result, the metrics compare the two.
+func WithShadow(service mmailer.Service, shadow mmailer.Service, opts ShadowOptions) mmailer.Service {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Minute
+	}
+	return &shadowService{
+		Service: service,
+		shadow:  shadow,
+		opts:    opts,
+		sample:  rand.Float64,
+	}
+}
+
+type shadowService struct {
+	mmailer.Service
+	shadow mmailer.Service
+	opts   ShadowOptions
+	sample func() float64
+}
+
+func (s *shadowService) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	start := time.Now()
+	res, err := s.Service.Send(ctx, email)
+	observeShadow(s.Name(), "primary", start, err)
+
+	if err == nil && !IsDryRun(ctx) && s.sample()*100 < s.opts.Percent {
+		go s.mirror(context.WithoutCancel(ctx), email)
+	}
+	return res, err
+}
+
+func (s *shadowService) mirror(ctx context.Context, email mmailer.Email) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.ErrorCtx(ctx, fmt.Errorf("%v", r), "shadow send panicked")
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	ctx = logger.AddToLogContext(ctx, "shadow", s.shadow.Name())
+
+	if len(s.opts.Seeds) > 0 {
+		email.To = s.opts.Seeds
+		email.Cc = nil
+		email.Individual = false
+	}
+	if !s.shadow.CanSend(email) {
+		shadowSent.WithLabelValues(s.shadow.Name(), "shadow", "skipped").Inc()
+		return
+	}
+	start := time.Now()
+	_, err := s.shadow.Send(ctx, email)
+	observeShadow(s.shadow.Name(), "shadow", start, err)
+	if err != nil {
+		logger.WarnCtx(ctx, fmt.Sprintf("shadow send failed: %v", err))
+	}
+}
+
+func (s *shadowService) Unwrap() mmailer.Service {
+	return s.Service
+}
+
+func observeShadow(service, role string, start time.Time, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	shadowSent.WithLabelValues(service, role, status).Inc()
+	shadowDuration.WithLabelValues(service, role).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,66 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type shadowTarget struct {
+	TestService
+	sent chan mmailer.Email
+	err  error
+}
+
+func (s *shadowTarget) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	s.sent <- email
+	return nil, s.err
+}
+
+func TestWithShadow(t *testing.T) {
+	target := &shadowTarget{TestService: TestService{"shadow"}, sent: make(chan mmailer.Email, 1), err: errors.New("boom")}
+	primary := &MockService{}
+	primary.On("Send", context.Background(), mmailer.Email{Subject: "mirrored"}).Return([]mmailer.Response{{MessageId: "1"}}, nil)
+
+	seeds := []mmailer.Address{{Email: "seed@example.com"}}
+	s := WithShadow(primary, target, ShadowOptions{Percent: 100, Seeds: seeds})
+
+	res, err := s.Send(context.Background(), mmailer.Email{Subject: "mirrored"})
+	require.NoError(t, err, "shadow errors don't affect the result")
+	assert.Equal(t, "1", res[0].MessageId)
+
+	select {
+	case email := <-target.sent:
+		assert.Equal(t, "mirrored", email.Subject)
+		assert.Equal(t, seeds, email.To)
+	case <-time.After(time.Second):
+		t.Fatal("email was not mirrored")
+	}
+}
+
+func TestWithShadow_NotMirrored(t *testing.T) {
+	target := &shadowTarget{TestService: TestService{"shadow"}, sent: make(chan mmailer.Email, 1)}
+	primary := &MockService{}
+	primary.On("Send", context.Background(), mmailer.Email{Subject: "failed"}).Return([]mmailer.Response{}, errors.New("boom"))
+	primary.On("Send", context.Background(), mmailer.Email{Subject: "sampled out"}).Return([]mmailer.Response{}, nil)
+
+	s := WithShadow(primary, target, ShadowOptions{Percent: 100})
+	_, err := s.Send(context.Background(), mmailer.Email{Subject: "failed"})
+	assert.Error(t, err)
+
+	s.(*shadowService).sample = func() float64 { return 0.5 }
+	s.(*shadowService).opts.Percent = 50
+	_, err = s.Send(context.Background(), mmailer.Email{Subject: "sampled out"})
+	assert.NoError(t, err)
+
+	select {
+	case email := <-target.sent:
+		t.Fatalf("%s was mirrored", email.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
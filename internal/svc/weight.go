@@ -59,3 +59,7 @@ func SelectWeighted(services []mmailer.Service) mmailer.Service {
 	}
 	return ws[len(ws)-1]
 }
+
+func (w *weightService) Unwrap() mmailer.Service {
+	return w.Service
+}
@@ -18,6 +18,9 @@ func (t *TestService) Name() string {
 func (t *TestService) Send(ctx context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
 	return
 }
+func (t *TestService) CanSend(email mmailer.Email) bool {
+	return true
+}
 func (t *TestService) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 	return nil, nil
 }
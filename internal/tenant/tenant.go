@@ -0,0 +1,168 @@
+package tenant
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrFromNotAllowed     = errors.New("from address is not allowed for tenant")
+	ErrTooManyRecipients  = errors.New("too many recipients")
+	ErrAttachmentTooLarge = errors.New("attachments are too large")
+	ErrQuotaExceeded      = errors.New("quota exceeded")
+)
+
+// Policy is what a tenant may send. Zero values means no restriction
+type Policy struct {
+	Id string `yaml:"id" json:"id"`
+	// Keys are the names of the api keys belonging to the tenant
+	Keys []string `yaml:"keys" json:"keys"`
+	// From are the allowed from addresses, or domains if they have no @
+	From               []string `yaml:"from" json:"from"`
+	MaxRecipients      int      `yaml:"max_recipients" json:"max_recipients"`
+	MaxAttachmentBytes int64    `yaml:"max_attachment_bytes" json:"max_attachment_bytes"`
+	// DailyQuota and MonthlyQuota are the number of recipients that may be sent to per UTC day and month
+	DailyQuota   int `yaml:"daily_quota" json:"daily_quota"`
+	MonthlyQuota int `yaml:"monthly_quota" json:"monthly_quota"`
+	// ServiceConfig is added to every email, after the config of the request so it takes precedence
+	ServiceConfig []mmailer.ConfigItem `yaml:"service_config" json:"service_config"`
+	// PreferredServices are tried in order when the request doesn't ask for a service
+	PreferredServices []string `yaml:"preferred_services" json:"preferred_services"`
+}
+
+// Recipients counts the recipients of an email, as they are counted against the quotas
+func Recipients(email mmailer.Email) int {
+	return len(email.To) + len(email.Cc)
+}
+
+func attachmentBytes(email mmailer.Email) int64 {
+	var size int64
+	for _, a := range email.Attachments {
+		c := strings.TrimRight(strings.TrimSpace(a.Content), "=")
+		size += int64(len(c)) * 3 / 4
+	}
+	return size
+}
+
+func (p *Policy) allowFrom(address string) bool {
+	if len(p.From) == 0 {
+		return true
+	}
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return false
+	}
+	addr := strings.ToLower(a.Address)
+	_, domain, _ := strings.Cut(addr, "@")
+	return slicez.ContainsBy(p.From, func(f string) bool {
+		f = strings.ToLower(f)
+		if strings.Contains(f, "@") {
+			return f == addr
+		}
+		return f == domain
+	})
+}
+
+// Check returns an error wrapping one of the Err* errors if the email breaks the policy. Quotas are checked by Usage.Reserve
+func (p *Policy) Check(email mmailer.Email) error {
+	if !p.allowFrom(email.From.Email) {
+		return fmt.Errorf("%w: %s", ErrFromNotAllowed, email.From.Email)
+	}
+	if p.MaxRecipients > 0 && Recipients(email) > p.MaxRecipients {
+		return fmt.Errorf("%w: %d, max is %d", ErrTooManyRecipients, Recipients(email), p.MaxRecipients)
+	}
+	if size := attachmentBytes(email); p.MaxAttachmentBytes > 0 && size > p.MaxAttachmentBytes {
+		return fmt.Errorf("%w: %d bytes, max is %d", ErrAttachmentTooLarge, size, p.MaxAttachmentBytes)
+	}
+	return nil
+}
+
+// Apply adds the service config of the tenant to the email
+func (p *Policy) Apply(email mmailer.Email) mmailer.Email {
+	if len(p.ServiceConfig) > 0 {
+		email.ServiceConfig = append(append([]mmailer.ConfigItem{}, email.ServiceConfig...), p.ServiceConfig...)
+	}
+	return email
+}
+
+// PreferredService returns the first of the preferred services that is among the available ones
+func (p *Policy) PreferredService(available []string) string {
+	for _, s := range p.PreferredServices {
+		if slicez.ContainsBy(available, func(a string) bool {
+			return strings.EqualFold(a, s)
+		}) {
+			return strings.ToLower(s)
+		}
+	}
+	return ""
+}
+
+// Tenants are the policies, looked up by the api key used
+type Tenants struct {
+	Tenants []Policy `yaml:"tenants" json:"tenants"`
+}
+
+// Load reads tenants from a yaml, or json, file
+func Load(path string) (*Tenants, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Tenants
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(&t)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return &t, t.validate()
+}
+
+func (t *Tenants) validate() error {
+	ids := map[string]bool{}
+	keys := map[string]string{}
+	for _, p := range t.Tenants {
+		if p.Id == "" {
+			return errors.New("tenant without id")
+		}
+		if ids[p.Id] {
+			return fmt.Errorf("tenant %q is defined more than once", p.Id)
+		}
+		ids[p.Id] = true
+		for _, k := range p.Keys {
+			if other, ok := keys[k]; ok {
+				return fmt.Errorf("api key %q belongs to both tenant %q and %q", k, other, p.Id)
+			}
+			keys[k] = p.Id
+		}
+	}
+	return nil
+}
+
+// ByKey returns the tenant of the named api key
+func (t *Tenants) ByKey(key string) (*Policy, bool) {
+	for i, p := range t.Tenants {
+		if slicez.Contains(p.Keys, key) {
+			return &t.Tenants[i], true
+		}
+	}
+	return nil, false
+}
+
+func (t *Tenants) Get(id string) (*Policy, bool) {
+	for i, p := range t.Tenants {
+		if p.Id == id {
+			return &t.Tenants[i], true
+		}
+	}
+	return nil, false
+}
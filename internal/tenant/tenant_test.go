@@ -0,0 +1,110 @@
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tenantsYaml = `
+tenants:
+  - id: billing
+    keys: [billing, billing-legacy]
+    from: [example.com, noreply@example.org]
+    max_recipients: 2
+    max_attachment_bytes: 6
+    daily_quota: 3
+    monthly_quota: 4
+    service_config:
+      - key: X-IpPool
+        value: billing
+    preferred_services: [mailjet, sendgrid]
+  - id: marketing
+    keys: [marketing]
+`
+
+func load(t *testing.T, content string) (*Tenants, error) {
+	path := filepath.Join(t.TempDir(), "tenants.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	tenants, err := load(t, tenantsYaml)
+	require.NoError(t, err)
+
+	p, ok := tenants.ByKey("billing-legacy")
+	require.True(t, ok)
+	assert.Equal(t, "billing", p.Id)
+	assert.Equal(t, mmailer.IpPool, p.ServiceConfig[0].Key)
+	_, ok = tenants.ByKey("unknown")
+	assert.False(t, ok)
+	_, ok = tenants.Get("marketing")
+	assert.True(t, ok)
+
+	_, err = load(t, "tenants:\n  - id: a\n    keys: [k]\n  - id: b\n    keys: [k]\n")
+	assert.Error(t, err)
+	_, err = load(t, "tenants:\n  - id: a\n  - id: a\n")
+	assert.Error(t, err)
+	_, err = load(t, "tenants:\n  - id: a\n    quota: 1\n")
+	assert.Error(t, err)
+}
+
+func TestPolicy_Check(t *testing.T) {
+	tenants, err := load(t, tenantsYaml)
+	require.NoError(t, err)
+	p, _ := tenants.Get("billing")
+
+	email := func(from string, to int, attachment string) mmailer.Email {
+		e := mmailer.Email{From: mmailer.Address{Email: from}}
+		for range to {
+			e.To = append(e.To, mmailer.Address{Email: "a@example.net"})
+		}
+		if attachment != "" {
+			e.Attachments = []mmailer.Attachment{{Content: attachment}}
+		}
+		return e
+	}
+
+	assert.NoError(t, p.Check(email("billing@Example.com", 2, "aGVsbG8=")))
+	assert.NoError(t, p.Check(email("noreply@example.org", 1, "")))
+	assert.ErrorIs(t, p.Check(email("other@example.org", 1, "")), ErrFromNotAllowed)
+	assert.ErrorIs(t, p.Check(email("billing@example.com", 3, "")), ErrTooManyRecipients)
+	assert.ErrorIs(t, p.Check(email("billing@example.com", 1, "aGVsbG8gd29ybGQ=")), ErrAttachmentTooLarge)
+
+	e := p.Apply(mmailer.Email{ServiceConfig: []mmailer.ConfigItem{{Key: mmailer.IpPool, Value: "other"}}})
+	assert.Equal(t, "billing", e.ServiceConfig[len(e.ServiceConfig)-1].Value)
+
+	assert.Equal(t, "sendgrid", p.PreferredService([]string{"sendgrid", "mandrill"}))
+	assert.Equal(t, "", p.PreferredService([]string{"mandrill"}))
+}
+
+func TestUsage(t *testing.T) {
+	tenants, err := load(t, tenantsYaml)
+	require.NoError(t, err)
+	p, _ := tenants.Get("billing")
+
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	u := NewUsage()
+	u.now = func() time.Time { return now }
+
+	require.NoError(t, u.Reserve(p, 2))
+	assert.ErrorIs(t, u.Reserve(p, 2), ErrQuotaExceeded, "daily")
+	u.Release(p, 1)
+	require.NoError(t, u.Reserve(p, 2))
+
+	r := u.Report(p)
+	assert.Equal(t, Period{Period: "2026-01-31", Used: 3, Quota: 3}, r.Day)
+	assert.Equal(t, Period{Period: "2026-01", Used: 3, Quota: 4}, r.Month)
+
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, u.Reserve(p, 3), "new day and month")
+
+	now = now.Add(24 * time.Hour)
+	assert.ErrorIs(t, u.Reserve(p, 2), ErrQuotaExceeded, "monthly")
+}
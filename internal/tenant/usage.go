@@ -0,0 +1,130 @@
+package tenant
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var sent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "tenant",
+	Name:      "recipients_count",
+	Help:      "The total number of recipients sent to, by tenant",
+}, []string{"tenant"})
+
+var rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "tenant",
+	Name:      "rejected_count",
+	Help:      "The total number of emails rejected by the policy of the tenant, by tenant and reason",
+}, []string{"tenant", "reason"})
+
+var quotaUsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "mmailer",
+	Subsystem: "tenant",
+	Name:      "quota_used",
+	Help:      "The number of recipients sent to in the current period, by tenant and period",
+}, []string{"tenant", "period"})
+
+// Sent counts recipients successfully sent to for the tenant
+func Sent(tenant string, n int) {
+	sent.WithLabelValues(tenant).Add(float64(n))
+}
+
+// Rejected counts an email rejected for the tenant
+func Rejected(tenant string, reason string) {
+	rejected.WithLabelValues(tenant, reason).Inc()
+}
+
+type Period struct {
+	Period string `json:"period"`
+	Used   int    `json:"used"`
+	Quota  int    `json:"quota,omitempty"`
+}
+
+type Report struct {
+	Tenant string `json:"tenant"`
+	Day    Period `json:"day"`
+	Month  Period `json:"month"`
+}
+
+type counter struct {
+	day, month     string
+	daily, monthly int
+}
+
+// Usage counts the recipients sent to per tenant, by UTC day and month. It is kept in memory
+// and starts over on restart.
+type Usage struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	counters map[string]*counter
+}
+
+func NewUsage() *Usage {
+	return &Usage{now: time.Now, counters: map[string]*counter{}}
+}
+
+func (u *Usage) current(tenant string) *counter {
+	now := u.now().UTC()
+	day, month := now.Format(time.DateOnly), now.Format("2006-01")
+	c, ok := u.counters[tenant]
+	if !ok {
+		c = &counter{}
+		u.counters[tenant] = c
+	}
+	if c.day != day {
+		c.day, c.daily = day, 0
+	}
+	if c.month != month {
+		c.month, c.monthly = month, 0
+	}
+	return c
+}
+
+// Reserve counts n recipients against the quotas of the tenant, or returns ErrQuotaExceeded if
+// they don't fit. A reservation that was not used, eg. the send failed, is given back with Release.
+func (u *Usage) Reserve(p *Policy, n int) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.current(p.Id)
+	if p.DailyQuota > 0 && c.daily+n > p.DailyQuota {
+		return fmt.Errorf("%w: daily quota of %d recipients, %d used", ErrQuotaExceeded, p.DailyQuota, c.daily)
+	}
+	if p.MonthlyQuota > 0 && c.monthly+n > p.MonthlyQuota {
+		return fmt.Errorf("%w: monthly quota of %d recipients, %d used", ErrQuotaExceeded, p.MonthlyQuota, c.monthly)
+	}
+	c.daily += n
+	c.monthly += n
+	u.observe(p.Id, c)
+	return nil
+}
+
+func (u *Usage) Release(p *Policy, n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.current(p.Id)
+	c.daily = max(c.daily-n, 0)
+	c.monthly = max(c.monthly-n, 0)
+	u.observe(p.Id, c)
+}
+
+func (u *Usage) observe(tenant string, c *counter) {
+	quotaUsed.WithLabelValues(tenant, "day").Set(float64(c.daily))
+	quotaUsed.WithLabelValues(tenant, "month").Set(float64(c.monthly))
+}
+
+func (u *Usage) Report(p *Policy) Report {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.current(p.Id)
+	return Report{
+		Tenant: p.Id,
+		Day:    Period{Period: c.day, Used: c.daily, Quota: p.DailyQuota},
+		Month:  Period{Period: c.month, Used: c.monthly, Quota: p.MonthlyQuota},
+	}
+}
@@ -0,0 +1,41 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// File polls path every interval and calls onChange when its modification time or size changes,
+// or when it appears or disappears. It returns when ctx is done.
+func File(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last := stat(path)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		cur := stat(path)
+		if cur != last {
+			last = cur
+			onChange()
+		}
+	}
+}
+
+type fileStat struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func stat(path string) fileStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
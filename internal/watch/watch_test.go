@@ -0,0 +1,34 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0o600))
+
+	var changes atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go File(ctx, path, 5*time.Millisecond, func() {
+		changes.Add(1)
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(0), changes.Load())
+
+	require.NoError(t, os.WriteFile(path, []byte("ab"), 0o600))
+	assert.Eventually(t, func() bool { return changes.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, os.Remove(path))
+	assert.Eventually(t, func() bool { return changes.Load() == 2 }, time.Second, 5*time.Millisecond)
+}
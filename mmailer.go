@@ -3,8 +3,10 @@ package mmailer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/modfin/henry/slicez"
@@ -12,9 +14,13 @@ import (
 )
 
 type Facade struct {
-	Services  []Service
-	Selecting SelectStrategy
-	Retry     RetryStrategy
+	Services []Service
+	// PosthookServices are services posthooks are unmarshalled by, besides Services, which are not used for sending,
+	// eg. a shadow service
+	PosthookServices []Service
+	Routing          RouteStrategy
+	Selecting        SelectStrategy
+	Retry            RetryStrategy
 }
 
 func New(selecting SelectStrategy, retry RetryStrategy, services ...Service) *Facade {
@@ -32,6 +38,12 @@ func (f *Facade) Send(ctx context.Context, email Email, preferredService string)
 	})
 
 	if len(services) == 0 {
+		if slicez.ContainsBy(f.Services, func(s Service) bool {
+			l, ok := LimitsOf(s)
+			return ok && !l.Allow(email)
+		}) {
+			return nil, fmt.Errorf("%w, it is about %d bytes", ErrTooLarge, email.Size())
+		}
 		return nil, errors.New("facade no services to use")
 	}
 
@@ -48,6 +60,13 @@ func (f *Facade) Send(ctx context.Context, email Email, preferredService string)
 		}
 	}
 
+	// Routing narrows the services down, unless one was specified
+	if service == nil && f.Routing != nil {
+		if routed := f.Routing(email, services); len(routed) > 0 {
+			services = routed
+		}
+	}
+
 	// Regular selection strategy
 	if service == nil {
 		strategy := f.Selecting
@@ -73,7 +92,25 @@ func (f *Facade) Send(ctx context.Context, email Email, preferredService string)
 	ctx = logger.AddToLogContext(ctx, "service", service.Name())
 	ctx = logger.AddToLogContext(ctx, "addresses", to)
 	logger.InfoCtx(ctx, "sending mail")
-	return retry(ctx, service, email, services)
+
+	report := ReportFrom(ctx)
+	if report == nil {
+		ctx, report = WithReport(ctx)
+	}
+	before := len(report.Attempts())
+	res, err = retry(ctx, service, email, services)
+
+	attempts := report.Attempts()[before:]
+	observeAttempts(attempts)
+	switch {
+	case err != nil:
+		logger.WarnCtx(ctx, fmt.Sprintf("could not send mail, tried %d services", len(attempts)), "attempts", attempts)
+	case len(attempts) > 1:
+		logger.WarnCtx(ctx, fmt.Sprintf("sent mail after trying %d services", len(attempts)), "attempts", attempts)
+	default:
+		logger.InfoCtx(ctx, "sent mail", "attempts", attempts)
+	}
+	return res, err
 }
 
 func (f *Facade) UnmarshalPosthook(r *http.Request) (res []Posthook, err error) {
@@ -82,7 +119,7 @@ func (f *Facade) UnmarshalPosthook(r *http.Request) (res []Posthook, err error)
 		return nil, err
 	}
 	name := strings.ToLower(r.URL.Query().Get("service"))
-	for _, s := range f.Services {
+	for _, s := range append(slices.Clone(f.Services), f.PosthookServices...) {
 		if s.Name() == name {
 			return s.UnmarshalPosthook(body)
 		}
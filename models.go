@@ -11,6 +11,10 @@ const (
 	IpPool          ConfigKey = "X-IpPool"
 	Vendor          ConfigKey = "X-Service"
 	DisableTracking ConfigKey = "X-Disable-Tracking"
+	// Hedge has the email sent by a second service as well, if the first has not responded within a threshold.
+	// The value is the threshold as a duration, eg. 500ms, or empty for the default. With a service, only the
+	// emails that service is selected for are hedged.
+	Hedge ConfigKey = "X-Hedge"
 )
 
 type Address struct {
@@ -22,6 +26,11 @@ type Attachment struct {
 	Name        string `json:"name"`
 	Content     string `json:"content"` // base64 encoded content
 	ContentType string `json:"content_type"`
+	// ContentId makes the attachment inline, referenced from the html as cid:<ContentId>
+	ContentId string `json:"content_id,omitempty"`
+	// URL or ObjectKey, of the configured object storage, is fetched by mmailerd instead of sending Content
+	URL       string `json:"url,omitempty"`
+	ObjectKey string `json:"object_key,omitempty"`
 }
 
 func (a Address) String() string {
@@ -37,6 +46,27 @@ type ConfigItem struct {
 	Value   string    `json:"value"`
 }
 
+type Priority string
+
+const (
+	// PriorityCritical is for emails that must not wait, eg. password resets and one-time passcodes
+	PriorityCritical Priority = "critical"
+	// PriorityNormal is the default
+	PriorityNormal Priority = "normal"
+	// PriorityBulk is for emails that may wait, eg. newsletters and digests
+	PriorityBulk Priority = "bulk"
+)
+
+var Priorities = []Priority{PriorityCritical, PriorityNormal, PriorityBulk}
+
+// Class returns the priority, or PriorityNormal if it is empty
+func (p Priority) Class() Priority {
+	if p == "" {
+		return PriorityNormal
+	}
+	return p
+}
+
 type Email struct {
 	Headers       map[string]string `json:"headers"`
 	ServiceConfig []ConfigItem      `json:"service_config"`
@@ -47,6 +77,25 @@ type Email struct {
 	Text          string            `json:"text"`
 	Html          string            `json:"html"`
 	Attachments   []Attachment      `json:"attachments"`
+	// Tags are used for routing, and sent as the tags or categories of the services that have them
+	Tags []string `json:"tags,omitempty"`
+	// Priority is the class of the email, which mmailerd sends with its own workers, services and retry strategy
+	Priority Priority `json:"priority,omitempty"`
+	// Individual sends one message per To recipient, with a response and message id for each
+	Individual bool `json:"individual,omitempty"`
+	// Raw is a complete RFC 5322 message that is sent as is, by the services that support it.
+	// From, To and Cc are then the envelope, and the other fields, except Subject, must be empty.
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// HasInline tells if any of the attachments is inline, which not every service supports
+func (e Email) HasInline() bool {
+	for _, a := range e.Attachments {
+		if a.ContentId != "" {
+			return true
+		}
+	}
+	return false
 }
 
 func (e *Email) DisableTracking() {
@@ -68,8 +117,23 @@ type Response struct {
 	Service   string `json:"service"`
 	MessageId string `json:"message_id"`
 	Email     string `json:"email"`
+	// Hedge is the outcome of the attempt when the send was hedged, see HedgeOutcome
+	Hedge HedgeOutcome `json:"hedge,omitempty"`
+	// Error is set for the recipients of an individual email it could not be sent to, when it was sent to others
+	Error string `json:"error,omitempty"`
 }
 
+type HedgeOutcome string
+
+const (
+	// HedgeWon is the attempt that succeeded first
+	HedgeWon HedgeOutcome = "won"
+	// HedgeFailed is an attempt that returned an error
+	HedgeFailed HedgeOutcome = "failed"
+	// HedgeCanceled is an attempt still running when the other won, it may still have been sent
+	HedgeCanceled HedgeOutcome = "canceled"
+)
+
 func (r Response) Id() string {
 	return fmt.Sprintf("%s:%s", r.Service, r.MessageId)
 }
@@ -115,7 +179,10 @@ type Posthook struct {
 	Email     string        `json:"email"`
 	Event     PosthookEvent `json:"event"`
 	Info      string        `json:"info,omitempty"`
+	Tags      []string      `json:"tags,omitempty"`
 	Timestamp time.Time     `json:"timestamp"`
+	// Shadow is set for events of a shadow service, about emails mirrored to it rather than sent by it
+	Shadow bool `json:"shadow,omitempty"`
 }
 
 func (r Posthook) Id() string {
@@ -0,0 +1,149 @@
+package mmailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var sendAttempts = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "mmailer",
+	Subsystem: "facade",
+	Name:      "send_attempts",
+	Help:      "The number of services tried per email",
+	Buckets:   []float64{1, 2, 3, 4, 5},
+})
+
+var sendAttemptCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "mmailer",
+	Subsystem: "facade",
+	Name:      "send_attempt_count",
+	Help:      "The total number of send attempts, by service and error class, or success",
+}, []string{"service", "class"})
+
+// ErrorClass is the kind of error a send attempt failed with
+type ErrorClass string
+
+const (
+	ErrorTimeout  ErrorClass = "timeout"
+	ErrorCanceled ErrorClass = "canceled"
+	ErrorTooLarge ErrorClass = "too_large"
+	ErrorNetwork  ErrorClass = "network"
+	// ErrorService is any other error, typically returned by the api of the service
+	ErrorService ErrorClass = "service"
+)
+
+// Classify returns the class of a send error
+func Classify(err error) ErrorClass {
+	var nerr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, ErrTooLarge):
+		return ErrorTooLarge
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &nerr):
+		return ErrorNetwork
+	default:
+		return ErrorService
+	}
+}
+
+// Attempt is the send of an email by one service
+type Attempt struct {
+	Service    string        `json:"service"`
+	Duration   time.Duration `json:"-"`
+	DurationMs int64         `json:"duration_ms"`
+	Error      string        `json:"error,omitempty"`
+	Class      ErrorClass    `json:"class,omitempty"`
+}
+
+// SendReport is the attempts made to send an email, in the order they returned
+type SendReport struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+func (r *SendReport) add(a Attempt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = append(r.attempts, a)
+}
+
+// Attempts returns the attempts made so far
+func (r *SendReport) Attempts() []Attempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Attempt{}, r.attempts...)
+}
+
+func (r *SendReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Attempts []Attempt `json:"attempts"`
+	}{r.Attempts()})
+}
+
+func (r *SendReport) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Attempts []Attempt `json:"attempts"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts = v.Attempts
+	return nil
+}
+
+type reportKey struct{}
+
+// WithReport returns a context that collects the send attempts of Facade.Send into the report
+func WithReport(ctx context.Context) (context.Context, *SendReport) {
+	r := &SendReport{}
+	return context.WithValue(ctx, reportKey{}, r), r
+}
+
+// ReportFrom returns the report of the context, or nil
+func ReportFrom(ctx context.Context) *SendReport {
+	r, _ := ctx.Value(reportKey{}).(*SendReport)
+	return r
+}
+
+// SendAttempt sends the email by the service and adds the attempt to the report of the context.
+// Retry strategies sends by it, so the attempts are reported.
+func SendAttempt(ctx context.Context, s Service, e Email) ([]Response, error) {
+	start := time.Now()
+	res, err := s.Send(ctx, e)
+	if r := ReportFrom(ctx); r != nil {
+		a := Attempt{Service: s.Name(), Duration: time.Since(start), Class: Classify(err)}
+		a.DurationMs = a.Duration.Milliseconds()
+		if err != nil {
+			a.Error = err.Error()
+		}
+		r.add(a)
+	}
+	return res, err
+}
+
+func observeAttempts(attempts []Attempt) {
+	sendAttempts.Observe(float64(len(attempts)))
+	for _, a := range attempts {
+		class := string(a.Class)
+		if class == "" {
+			class = "success"
+		}
+		sendAttemptCount.WithLabelValues(a.Service, class).Inc()
+	}
+}
@@ -0,0 +1,28 @@
+package mmailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{nil, ""},
+		{fmt.Errorf("send: %w", context.DeadlineExceeded), ErrorTimeout},
+		{context.Canceled, ErrorCanceled},
+		{fmt.Errorf("%w, it is about 1 bytes", ErrTooLarge), ErrorTooLarge},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrorNetwork},
+		{errors.New("401 unauthorized"), ErrorService},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.class, Classify(tc.err), "%v", tc.err)
+	}
+}
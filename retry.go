@@ -2,10 +2,41 @@ package mmailer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 )
 
 type RetryStrategy func(cxt context.Context, serviceToUse Service, email Email, backupServices []Service) (res []Response, err error)
 
 func RetryNone(ctx context.Context, s Service, e Email, _ []Service) (res []Response, err error) {
-	return s.Send(ctx, e)
+	return SendAttempt(ctx, s, e)
+}
+
+// PartialError is the error of an individual email that was sent to some of its recipients but not to all.
+// Retry strategies only send it again to the recipients that failed.
+type PartialError struct {
+	// Sent are the responses of the recipients it was sent to
+	Sent []Response
+	// Failed are the recipients it was not sent to, and Err why
+	Failed []Address
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("sent to %d recipients, failed for %d: %v", len(e.Sent), len(e.Failed), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Remaining returns the email to send again after err. If it was partially sent, that is only to the recipients
+// that failed, along with the responses of those it was sent to.
+func Remaining(e Email, err error) (Email, []Response) {
+	var perr *PartialError
+	if !errors.As(err, &perr) {
+		return e, nil
+	}
+	e.To = perr.Failed
+	return e, perr.Sent
 }
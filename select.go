@@ -4,6 +4,10 @@ import "math/rand"
 
 type SelectStrategy func([]Service) Service
 
+// RouteStrategy narrows down the services that can send an email to the ones that should, before selection.
+// Returning none leaves the choice to the SelectStrategy among all of them.
+type RouteStrategy func(email Email, services []Service) []Service
+
 func SelectRandom(s []Service) Service {
 	i := rand.Int31n(int32(len(s)))
 	return s[i]
@@ -1,11 +1,15 @@
 package brev
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 
+	"github.com/google/uuid"
 	"github.com/modfin/brev"
 	"github.com/modfin/mmailer"
 	"github.com/modfin/mmailer/internal/logger"
@@ -17,6 +21,13 @@ type Brev struct {
 	confer services.Configurer[*brev.Email]
 }
 
+// Server is a server that brev delivers through, and the cname of its mx
+type Server struct {
+	Host    string
+	MxCNAME string
+}
+
+// New creates brev from the parts of a SERVICES string, domain and key followed by pairs of server host and mx cname
 func New(configParts []string, posthookUrl string) (*Brev, error) {
 
 	if len(configParts) < 2 {
@@ -30,21 +41,27 @@ func New(configParts []string, posthookUrl string) (*Brev, error) {
 		return nil, errors.New("brev: invalid number of key parts")
 	}
 
-	var servers []brev.TargetServer
+	var servers []Server
 	for i := 0; i < len(configParts); i += 2 {
-		c := brev.TargetServer{
-			Host:    configParts[i],
-			MxCNAME: configParts[i+1],
-		}
-		fmt.Printf(" - Brev: added config: %s (%s)\n", c.Host, c.MxCNAME)
-		c.Host = "https://" + c.Host
-		servers = append(servers, c)
+		servers = append(servers, Server{Host: configParts[i], MxCNAME: configParts[i+1]})
 	}
-	if len(servers) == 0 {
+	return NewWithServers(domain, key, servers, posthookUrl)
+}
+
+func NewWithServers(domain string, key string, servers []Server, posthookUrl string) (*Brev, error) {
+	var targets []brev.TargetServer
+	for _, s := range servers {
+		logger.Info(fmt.Sprintf(" - Brev: added config: %s (%s)", s.Host, s.MxCNAME))
+		targets = append(targets, brev.TargetServer{
+			Host:    "https://" + s.Host,
+			MxCNAME: s.MxCNAME,
+		})
+	}
+	if len(targets) == 0 {
 		return nil, errors.New("brev: no servers")
 	}
 
-	b, err := brev.New(key, domain, posthookUrl, servers, 0)
+	b, err := brev.New(key, domain, posthookUrl, targets, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -58,11 +75,20 @@ func (b *Brev) Name() string {
 	return "brev"
 }
 
+// Limits are not documented by brev, neither in total nor of attachments, the size is left to the api to reject
+func (*Brev) Limits() mmailer.Limits {
+	return mmailer.Limits{}
+}
+
 func (*Brev) CanSend(email mmailer.Email) bool {
-	return true // per domain keys not implemented
+	// raw messages and inline attachments are not supported, per domain keys not implemented
+	return len(email.Raw) == 0 && !email.HasInline()
 }
 
 func (b *Brev) Send(ctx context.Context, m mmailer.Email) (res []mmailer.Response, err error) {
+	if m.Individual {
+		return services.SendIndividually(ctx, m, b.Send)
+	}
 	if b.client == nil {
 		return nil, errors.New("brev: cant send, missing client")
 	}
@@ -79,7 +105,7 @@ func (b *Brev) Send(ctx context.Context, m mmailer.Email) (res []mmailer.Respons
 		})
 	}
 	for _, c := range m.Cc {
-		bm.To = append(bm.Cc, brev.Address{
+		bm.Cc = append(bm.Cc, brev.Address{
 			Name:  c.Name,
 			Email: c.Email,
 		})
@@ -110,7 +136,6 @@ func (b *Brev) Send(ctx context.Context, m mmailer.Email) (res []mmailer.Respons
 	return []mmailer.Response{{
 		Service:   b.Name(),
 		MessageId: r.MessageId,
-		//Email: TODO: ???
 	}}, nil
 }
 
@@ -139,13 +164,37 @@ func (b *Brev) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 	default:
 		ev = mmailer.EventUnknown
 	}
-	return []mmailer.Posthook{{
-		Service:   b.Name(),
-		MessageId: hook.MessageId,
-		Email:     "",
-		Event:     ev,
-		Info:      hook.Info,
-	}}, nil
+
+	emails := hook.Emails
+	if len(emails) == 0 {
+		emails = []string{""}
+	}
+	var res []mmailer.Posthook
+	for _, email := range emails {
+		res = append(res, mmailer.Posthook{
+			Service:   b.Name(),
+			EventId:   createEventId(&hook, email),
+			MessageId: hook.MessageId,
+			Email:     email,
+			Event:     ev,
+			Info:      hook.Info,
+			Timestamp: hook.CreatedAt,
+		})
+	}
+	return res, nil
+}
+
+// Brev posthooks does not carry an event id, and may concern several recipients.
+// Create a deterministic id per recipient, so redeliveries can be deduplicated.
+// If you add more fields to the hash, also change the namespace uuid below, so we don't get collisions.
+func createEventId(h *brev.Posthook, email string) string {
+	var buf bytes.Buffer
+	buf.WriteString(h.MessageId)
+	buf.WriteString(strconv.FormatInt(h.TransactionId, 10))
+	buf.WriteString(string(h.Event))
+	buf.WriteString(strconv.FormatInt(h.CreatedAt.UnixNano(), 10))
+	buf.WriteString(email)
+	return uuid.NewHash(sha256.New(), uuid.MustParse("5a976b7b-f77d-480c-ab2f-3ff0a0cb00cb"), buf.Bytes(), 4).String()
 }
 
 type BrevConfigurer struct{}
@@ -98,3 +98,45 @@ func setupMessage() (message *brevpkg.Email, originalMessage *brevpkg.Email) {
 
 	return
 }
+
+func TestBrev_UnmarshalPosthook_EventId(t *testing.T) {
+	b := &Brev{}
+	body := []byte(`{"message_id":"abc","transaction_id":1,"emails":["a@example.com","b@example.com"],"CreatedAt":"2024-01-02T03:04:05Z","event":"delivered"}`)
+
+	hooks, err := b.UnmarshalPosthook(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("Expected one hook per email, got %d", len(hooks))
+	}
+	if hooks[0].Email != "a@example.com" || hooks[1].Email != "b@example.com" {
+		t.Errorf("Expected emails to be set, got %q and %q", hooks[0].Email, hooks[1].Email)
+	}
+	if hooks[0].EventId == "" || hooks[0].EventId == hooks[1].EventId {
+		t.Errorf("Expected distinct event ids, got %q and %q", hooks[0].EventId, hooks[1].EventId)
+	}
+	if hooks[0].Timestamp.IsZero() {
+		t.Errorf("Expected timestamp to be set")
+	}
+
+	again, err := b.UnmarshalPosthook(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[1].EventId != hooks[1].EventId {
+		t.Errorf("Expected event id to be stable across redeliveries")
+	}
+}
+
+func TestBrev_CanSendInline(t *testing.T) {
+	b := &Brev{}
+	email := mmailer.Email{Attachments: []mmailer.Attachment{{Name: "invoice.pdf", Content: "cGRm"}}}
+	if !b.CanSend(email) {
+		t.Errorf("Expected an email with attachments to be sendable")
+	}
+	email.Attachments = append(email.Attachments, mmailer.Attachment{Name: "logo.png", Content: "cG5n", ContentId: "logo"})
+	if b.CanSend(email) {
+		t.Errorf("Expected an email with inline attachments to fail over to another service")
+	}
+}
@@ -12,6 +12,14 @@ type Configurer[T any] interface {
 	DisableTracking(message T)
 }
 
+// ConfigFor returns the items of conf that ApplyConfig applies to service, eg. to record them where no Configurer is
+// run. Whether the service supports them is up to its Configurer.
+func ConfigFor(service string, conf []mmailer.ConfigItem) []mmailer.ConfigItem {
+	return slicez.Filter(conf, func(ci mmailer.ConfigItem) bool {
+		return (ci.Service == "" || ci.Service == service) && (ci.Key == mmailer.IpPool || ci.Key == mmailer.DisableTracking)
+	})
+}
+
 func ApplyConfig[T any](service string, conf []mmailer.ConfigItem, configurer Configurer[T], m T) {
 	logger.Info("Applying config")
 	conf = slicez.Filter(conf, func(ci mmailer.ConfigItem) bool {
@@ -23,8 +31,8 @@ func ApplyConfig[T any](service string, conf []mmailer.ConfigItem, configurer Co
 		case mmailer.IpPool:
 			logger.Info(fmt.Sprintf("applying IpPool: %s", c.Value))
 			configurer.SetIpPool(c.Value, m)
-		case mmailer.Vendor:
-			// no op, maybe we should just remove this item in mmailerd when we read it
+		case mmailer.Vendor, mmailer.Hedge:
+			// no op, maybe we should just remove this item in mmailerd when we read it, hedging is done by the facade
 		case mmailer.DisableTracking:
 			logger.Info("disabling tracking")
 			configurer.DisableTracking(m)
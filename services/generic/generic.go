@@ -2,13 +2,11 @@ package generic
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/smtp"
 	"net/url"
-	"os"
-	"strings"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/modfin/mmailer"
@@ -20,15 +18,22 @@ import (
 // make generic implement mmailer.Service interface by implementing Name and Send methods
 type Generic struct {
 	smtpUrl *url.URL
+	limits  mmailer.Limits
 	confer  services.Configurer[*smtpx.Message]
 }
 
+// New creates a service sending through the smtp server of the url. The size limits of the server may be
+// given as the query parameters max_size and max_attachment_size, in bytes.
 func New(smtpUrl *url.URL) *Generic {
 	if smtpUrl.Port() == "" {
 		smtpUrl.Host = smtpUrl.Host + ":25"
 	}
+	limits := mmailer.Limits{}
+	limits.MaxMessageBytes, _ = strconv.Atoi(smtpUrl.Query().Get("max_size"))
+	limits.MaxAttachmentBytes, _ = strconv.Atoi(smtpUrl.Query().Get("max_attachment_size"))
 	return &Generic{
 		smtpUrl: smtpUrl,
+		limits:  limits,
 		confer:  GenericConfigurer{},
 	}
 }
@@ -37,89 +42,20 @@ func (g *Generic) Name() string {
 	return fmt.Sprintf("Generic smtp %s", g.smtpUrl.Host)
 }
 
+func (g *Generic) Limits() mmailer.Limits {
+	return g.limits
+}
+
 func (g *Generic) CanSend(email mmailer.Email) bool {
-	return true // per domain keys not implemented
+	return g.limits.Allow(email) // per domain keys not implemented
 }
 
 func (g *Generic) Send(ctx context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
-	message := smtpx.NewMessage()
-	for k, v := range email.Headers {
-		message.SetHeader(k, v)
+	if email.Individual {
+		return services.SendIndividually(ctx, email, g.Send)
 	}
-
-	message.SetHeader("From", email.From.String())
 	ctx = logger.AddToLogContext(ctx, "from", email.From.String())
 
-	var recp []string
-	if len(email.To) > 0 {
-		var tos []string
-		for _, t := range email.To {
-			tos = append(tos, t.String())
-			recp = append(recp, t.Email)
-		}
-		message.SetHeader("To", strings.Join(tos, ", "))
-	}
-	if len(email.Cc) > 0 {
-		var tos []string
-		for _, t := range email.To {
-			tos = append(tos, t.String())
-			recp = append(recp, t.Email)
-		}
-		message.SetHeader("To", strings.Join(tos, ", "))
-	}
-	message.SetHeader("Subject", email.Subject)
-
-	if len(email.Text) > 0 {
-		message.SetBody("text/plain", email.Text)
-	}
-	if len(email.Html) > 0 {
-		message.SetBody("text/html", email.Html)
-	}
-
-	var files []*os.File
-	if len(email.Attachments) > 0 {
-		// create a new temp file based on attachment content
-		for _, a := range email.Attachments {
-			// create a new temp file based on attachment content
-			if strings.Contains(a.Name, "/") || strings.Contains(a.Name, "\\") || strings.Contains(a.Name, "..") {
-				logger.ErrorCtx(ctx, fmt.Errorf("invalid attachment name: %s", a.Name), "attachment name failed validation")
-				continue
-			}
-			f, err := os.Create("/tmp/" + a.Name)
-			if err != nil {
-				logger.ErrorCtx(ctx, err, "could not create temp file")
-				continue
-			}
-
-			b64decoded, err := base64.StdEncoding.DecodeString(a.Content)
-			if err != nil {
-				logger.ErrorCtx(ctx, err, "could not decode base64 content")
-				continue
-			}
-			_, err = f.Write(b64decoded)
-			if err != nil {
-				logger.ErrorCtx(ctx, err, "could not write to temp file")
-				continue
-			}
-			files = append(files, f)
-			message.Attach(f.Name())
-		}
-	}
-
-	defer func() {
-		for _, f := range files {
-			f := f
-			err := f.Close()
-			if err != nil {
-				logger.ErrorCtx(ctx, err, "could not close temp file: "+f.Name())
-			}
-			err = os.Remove(f.Name())
-			if err != nil {
-				logger.ErrorCtx(ctx, err, "could not remove temp file: "+f.Name())
-			}
-		}
-	}()
-
 	var auth smtp.Auth = nil
 
 	user := g.smtpUrl.User.Username()
@@ -128,13 +64,12 @@ func (g *Generic) Send(ctx context.Context, email mmailer.Email) (res []mmailer.
 		auth = smtp.CRAMMD5Auth(user, pass)
 	}
 
-	msgId := uuid.NewString()
-	message.SetHeader("Message-ID", msgId)
-	msg, err := message.Bytes()
+	msgId, msg, err := g.render(email)
 	if err != nil {
+		logger.ErrorCtx(ctx, err, "could not build message")
 		return nil, err
 	}
-	err = smtp.SendMail(g.smtpUrl.Host, auth, email.From.Email, recp, msg)
+	err = smtp.SendMail(g.smtpUrl.Host, auth, email.From.Email, smtpx.Recipients(email), msg)
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +86,21 @@ func (g *Generic) Send(ctx context.Context, email mmailer.Email) (res []mmailer.
 	return resps, nil
 }
 
+// render returns the message id and bytes of the email, raw emails are sent as is
+func (g *Generic) render(email mmailer.Email) (string, []byte, error) {
+	if len(email.Raw) > 0 {
+		return smtpx.MessageId(email.Raw), email.Raw, nil
+	}
+	message, err := smtpx.FromEmail(email)
+	if err != nil {
+		return "", nil, err
+	}
+	msgId := uuid.NewString()
+	message.SetHeader("Message-ID", msgId)
+	msg, err := message.Bytes()
+	return msgId, msg, err
+}
+
 func (m *Generic) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 	return nil, errors.New("generic smtp does not have post hooks")
 }
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/modfin/mmailer"
+)
+
+// SendIndividually sends one message per To recipient with send, for emails with Individual set.
+// Every message has all the Cc recipients. Every response has Email set, so posthooks can be matched
+// to recipients. A failure for one recipient doesn't stop the others, if some were sent to, a
+// *mmailer.PartialError with the recipients that failed is returned along with their responses.
+func SendIndividually(ctx context.Context, email mmailer.Email, send func(context.Context, mmailer.Email) ([]mmailer.Response, error)) ([]mmailer.Response, error) {
+	var res []mmailer.Response
+	var failed []mmailer.Address
+	var errs []error
+	for _, to := range email.To {
+		single := email
+		single.Individual = false
+		single.To = []mmailer.Address{to}
+		r, err := send(ctx, single)
+		if err != nil {
+			failed = append(failed, to)
+			errs = append(errs, fmt.Errorf("%s: %w", to.Email, err))
+			continue
+		}
+		for _, resp := range r {
+			if resp.Email == "" {
+				resp.Email = to.Email
+			}
+			res = append(res, resp)
+		}
+	}
+	switch {
+	case len(errs) == 0:
+		return res, nil
+	case len(res) == 0:
+		return nil, errors.Join(errs...)
+	}
+	return res, &mmailer.PartialError{Sent: res, Failed: failed, Err: errors.Join(errs...)}
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/modfin/mmailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendIndividually(t *testing.T) {
+	email := mmailer.Email{
+		From:       mmailer.Address{Email: "jon@example.com"},
+		To:         []mmailer.Address{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}},
+		Cc:         []mmailer.Address{{Email: "cc@example.com"}},
+		Individual: true,
+	}
+
+	var sent []mmailer.Email
+	res, err := SendIndividually(context.Background(), email, func(ctx context.Context, e mmailer.Email) ([]mmailer.Response, error) {
+		sent = append(sent, e)
+		if e.To[0].Email == "b@example.com" {
+			return nil, errors.New("rejected")
+		}
+		// like sendgrid and brev, one id without recipient
+		return []mmailer.Response{{Service: "test", MessageId: "id-" + e.To[0].Email}}, nil
+	})
+
+	require.Len(t, sent, 3)
+	for _, e := range sent {
+		assert.False(t, e.Individual)
+		assert.Len(t, e.To, 1)
+	}
+	for _, e := range sent {
+		assert.Equal(t, email.Cc, e.Cc, "every message has the cc recipients")
+	}
+
+	assert.Equal(t, []mmailer.Response{
+		{Service: "test", MessageId: "id-a@example.com", Email: "a@example.com"},
+		{Service: "test", MessageId: "id-c@example.com", Email: "c@example.com"},
+	}, res)
+	var perr *mmailer.PartialError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorContains(t, err, "b@example.com: rejected")
+	assert.Equal(t, []mmailer.Address{{Email: "b@example.com"}}, perr.Failed)
+	assert.Equal(t, res, perr.Sent)
+}
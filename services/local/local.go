@@ -0,0 +1,71 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/modfin/henry/slicez"
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/modfin/mmailer/services"
+)
+
+// Local implements mmailer.Service by delivering into an in-process mailbox, for development.
+// Every email is followed by synthetic processed and delivered posthooks for each recipient.
+type Local struct {
+	box   *mailbox.Mailbox
+	hooks func([]mmailer.Posthook)
+}
+
+// New delivers emails into box, and passes the posthooks of each email to hooks
+func New(box *mailbox.Mailbox, hooks func([]mmailer.Posthook)) *Local {
+	return &Local{box: box, hooks: hooks}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) CanSend(email mmailer.Email) bool {
+	return true
+}
+
+func (l *Local) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	if email.Individual {
+		return services.SendIndividually(ctx, email, l.Send)
+	}
+	res, err := l.box.Capture(l.Name(), email)
+	if err != nil {
+		return nil, err
+	}
+	if l.hooks != nil && len(res) > 0 {
+		hooks := l.posthooks(res[0].MessageId, email)
+		// Like a vendor, the posthooks arrive after the send has returned
+		go l.hooks(hooks)
+	}
+	return res, nil
+}
+
+func (l *Local) posthooks(messageId string, email mmailer.Email) []mmailer.Posthook {
+	now := time.Now()
+	var hooks []mmailer.Posthook
+	for _, event := range []mmailer.PosthookEvent{mmailer.EventProcessed, mmailer.EventDelivered} {
+		hooks = append(hooks, slicez.Map(slicez.Concat(email.To, email.Cc), func(a mmailer.Address) mmailer.Posthook {
+			return mmailer.Posthook{
+				Service:   l.Name(),
+				EventId:   uuid.NewString(),
+				MessageId: messageId,
+				Email:     a.Email,
+				Event:     event,
+				Timestamp: now,
+			}
+		})...)
+	}
+	return hooks
+}
+
+func (l *Local) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
+	return nil, errors.New("local posthooks are emitted directly, not received")
+}
@@ -0,0 +1,52 @@
+package local
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal_Send(t *testing.T) {
+	box := mailbox.New(10)
+	got := make(chan []mmailer.Posthook, 1)
+	l := New(box, func(hooks []mmailer.Posthook) {
+		got <- hooks
+	})
+
+	res, err := l.Send(context.Background(), mmailer.Email{
+		From:    mmailer.Address{Email: "jon@example.com"},
+		To:      []mmailer.Address{{Email: "jane@example.com"}},
+		Cc:      []mmailer.Address{{Email: "bob@example.com"}},
+		Subject: "Hello",
+		Text:    "Hi",
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "local", res[0].Service)
+
+	msg, ok := box.Get(res[0].MessageId)
+	require.True(t, ok)
+	assert.Equal(t, "Hello", msg.Subject)
+
+	select {
+	case hooks := <-got:
+		require.Len(t, hooks, 4)
+		for i, h := range hooks {
+			assert.Equal(t, res[0].MessageId, h.MessageId)
+			assert.NotEmpty(t, h.EventId)
+			if i < 2 {
+				assert.Equal(t, mmailer.EventProcessed, h.Event)
+			} else {
+				assert.Equal(t, mmailer.EventDelivered, h.Event)
+			}
+		}
+		assert.Equal(t, "bob@example.com", hooks[1].Email)
+	case <-time.After(time.Second):
+		t.Fatal("no posthooks emitted")
+	}
+}
@@ -1,10 +1,12 @@
 package mailgun
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"net/mail"
 	"strings"
 	"time"
@@ -38,7 +40,15 @@ func (m *Mailgun) Name() string {
 	return "mailgun"
 }
 
+// Limits are those of the mailgun messages api, 25 MB in total. Attachments have no limit of their own but the total.
+func (m *Mailgun) Limits() mmailer.Limits {
+	return mmailer.Limits{MaxMessageBytes: 25 << 20}
+}
+
 func (m *Mailgun) CanSend(e mmailer.Email) bool {
+	if !m.Limits().Allow(e) {
+		return false
+	}
 	for _, a := range e.Attachments {
 		// TODO Can't find the option to set attachment content type in the mailgun api, can it be fixed?
 		if a.ContentType != "" && a.ContentType != "application/octet-stream" {
@@ -71,6 +81,9 @@ func (m *Mailgun) newClient(addr string) (*mailgun.Client, error) {
 }
 
 func (m *Mailgun) Send(ctx context.Context, e mmailer.Email) ([]mmailer.Response, error) {
+	if e.Individual {
+		return services.SendIndividually(ctx, e, m.Send)
+	}
 	from, err := mail.ParseAddress(e.From.String())
 	if err != nil {
 		return nil, fmt.Errorf("mailgun: failed to parse email: %w", err)
@@ -87,6 +100,13 @@ func (m *Mailgun) Send(ctx context.Context, e mmailer.Email) ([]mmailer.Response
 	if domain == "" {
 		return nil, fmt.Errorf("mailgun: failed to get email domain: %v", from.Address)
 	}
+	if len(e.Raw) > 0 {
+		// the raw message carries its own headers, the recipients are the envelope
+		rcpt := slicez.Map(slicez.Concat(e.To, e.Cc), func(a mmailer.Address) string {
+			return a.Email
+		})
+		return m.send(ctx, client, mailgun.NewMIMEMessage(domain, io.NopCloser(bytes.NewReader(e.Raw)), rcpt...))
+	}
 	msg := mailgun.NewMessage(domain, from.String(), e.Subject, e.Text, to...)
 	services.ApplyConfig(m.Name(), e.ServiceConfig, m.confer, msg)
 
@@ -96,6 +116,11 @@ func (m *Mailgun) Send(ctx context.Context, e mmailer.Email) ([]mmailer.Response
 	for k, v := range e.Headers {
 		msg.AddHeader(k, v)
 	}
+	for _, t := range e.Tags {
+		if err := msg.AddTag(t); err != nil {
+			logger.WarnCtx(ctx, fmt.Sprintf("mailgun: skipping tag %s: %v", t, err))
+		}
+	}
 	if strings.TrimSpace(e.Html) != "" {
 		msg.SetHTML(e.Html)
 	}
@@ -105,9 +130,18 @@ func (m *Mailgun) Send(ctx context.Context, e mmailer.Email) ([]mmailer.Response
 		if err != nil {
 			return nil, fmt.Errorf("mailgun: failed to decode attachment: %w", err)
 		}
+		if a.ContentId != "" {
+			// mailgun uses the filename of inline attachments as their content id
+			msg.AddReaderInline(a.ContentId, io.NopCloser(bytes.NewReader(b)))
+			continue
+		}
 		msg.AddBufferAttachment(a.Name, b)
 	}
 
+	return m.send(ctx, client, msg)
+}
+
+func (m *Mailgun) send(ctx context.Context, client *mailgun.Client, msg mailgun.Message) ([]mmailer.Response, error) {
 	resp, err := client.Send(ctx, msg)
 	if err != nil {
 		return nil, fmt.Errorf("mailgun: failed to send email: %w", err)
@@ -159,17 +193,20 @@ func (m *Mailgun) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 		h.Event = mmailer.EventProcessed
 		h.MessageId = e.Message.Headers.MessageID
 		h.Email = e.Recipient
+		h.Tags = e.Tags
 
 	case *events.Delivered:
 		h.Event = mmailer.EventDelivered
 		h.MessageId = e.Message.Headers.MessageID
 		h.Email = e.Recipient
+		h.Tags = e.Tags
 		h.Info = infoString(false, "", "", e.DeliveryStatus)
 
 	case *events.Opened:
 		h.Event = mmailer.EventOpen
 		h.MessageId = e.Message.Headers.MessageID
 		h.Email = e.Recipient
+		h.Tags = e.Tags
 
 	case *events.Failed:
 		switch e.Severity {
@@ -183,6 +220,7 @@ func (m *Mailgun) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 		}
 		h.MessageId = e.Message.Headers.MessageID
 		h.Email = e.Recipient
+		h.Tags = e.Tags
 		h.Info = infoString(true, e.Reason, e.Severity, e.DeliveryStatus)
 
 	default:
@@ -1,11 +1,16 @@
 package mailjet
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	mj "github.com/mailjet/mailjet-apiv3-go/v3"
 	"github.com/modfin/mmailer"
 	"github.com/modfin/mmailer/services"
@@ -72,11 +77,43 @@ func (m *Mailjet) Name() string {
 	return "mailjet"
 }
 
-func (*Mailjet) CanSend(email mmailer.Email) bool {
-	return true // per domain keys not implemented
+// Limits are those of the mailjet send api, 15 MB in total. Attachments have no limit of their own but the total.
+func (*Mailjet) Limits() mmailer.Limits {
+	return mmailer.Limits{MaxMessageBytes: 15 << 20}
 }
 
-func (m *Mailjet) Send(_ context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+func (m *Mailjet) CanSend(email mmailer.Email) bool {
+	return len(email.Raw) == 0 && m.Limits().Allow(email) // raw messages are not supported, per domain keys not implemented
+}
+
+// attachments splits the attachments of the email into those that are attached and those that are inline,
+// nil if there are none
+func attachments(email mmailer.Email) (attached *mj.AttachmentsV31, inlined *mj.InlinedAttachmentsV31) {
+	for _, a := range email.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		attachment := mj.AttachmentV31{ContentType: contentType, Base64Content: a.Content, Filename: a.Name}
+		if a.ContentId != "" {
+			if inlined == nil {
+				inlined = &mj.InlinedAttachmentsV31{}
+			}
+			*inlined = append(*inlined, mj.InlinedAttachmentV31{AttachmentV31: attachment, ContentID: a.ContentId})
+			continue
+		}
+		if attached == nil {
+			attached = &mj.AttachmentsV31{}
+		}
+		*attached = append(*attached, attachment)
+	}
+	return attached, inlined
+}
+
+func (m *Mailjet) Send(ctx context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+	if email.Individual {
+		return services.SendIndividually(ctx, email, m.Send)
+	}
 	message := mj.InfoMessagesV31{
 		Headers: map[string]interface{}{},
 		From: &mj.RecipientV31{
@@ -113,6 +150,7 @@ func (m *Mailjet) Send(_ context.Context, email mmailer.Email) (res []mmailer.Re
 	}
 	message.To = &to
 	message.Cc = &cc
+	message.Attachments, message.InlinedAttachments = attachments(email)
 
 	messages := mj.MessagesV31{Info: []mj.InfoMessagesV31{message}}
 
@@ -154,6 +192,7 @@ type posthook struct {
 	Comment        string `json:"comment"`
 	Error          string `json:"error"`
 	Source         string `json:"source"`
+	URL            string `json:"url"`
 }
 
 func (m *Mailjet) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
@@ -200,10 +239,12 @@ func (m *Mailjet) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 
 		res = append(res, mmailer.Posthook{
 			Service:   m.Name(),
+			EventId:   createEventId(&h),
 			MessageId: h.MessageGUID,
 			Email:     h.Email,
 			Event:     event,
 			Info:      info,
+			Timestamp: time.Unix(int64(h.Time), 0),
 		})
 	}
 	return res, nil
@@ -216,3 +257,16 @@ func (s MailjetConfigurer) SetIpPool(poolId string, message *mj.MessagesV31) {
 }
 
 func (s MailjetConfigurer) DisableTracking(message *mj.MessagesV31) {}
+
+// Mailjet events does not carry any id of their own.
+// Create a deterministic one, based on the fields that tells events apart, so redeliveries can be deduplicated.
+// If you add more fields to the hash, also change the namespace uuid below, so we don't get collisions.
+func createEventId(h *posthook) string {
+	var buf bytes.Buffer
+	buf.WriteString(h.MessageGUID)
+	buf.WriteString(h.Event)
+	buf.WriteString(strconv.Itoa(h.Time))
+	buf.WriteString(h.Email)
+	buf.WriteString(h.URL)
+	return uuid.NewHash(sha256.New(), uuid.MustParse("44c8a98d-6473-41b4-9f4c-884be578e49e"), buf.Bytes(), 4).String()
+}
@@ -114,3 +114,51 @@ func setupMessage() (message *mj.MessagesV31, originalMessage *mj.MessagesV31) {
 	}
 	return
 }
+
+func TestMailjet_UnmarshalPosthook_EventId(t *testing.T) {
+	m := New("", "")
+	body := []byte(`[
+{"event":"open","time":1433103519,"MessageID":19421777396190490,"Message_GUID":"1ab23cd4-e567-8901-2345-6789f0gh1i2j","email":"api@mailjet.com"},
+{"event":"click","time":1433103519,"MessageID":19421777396190490,"Message_GUID":"1ab23cd4-e567-8901-2345-6789f0gh1i2j","email":"api@mailjet.com","url":"https://mailjet.com"}
+]`)
+
+	hooks, err := m.UnmarshalPosthook(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("Expected 2 hooks, got %d", len(hooks))
+	}
+	if hooks[0].EventId == "" || hooks[0].EventId == hooks[1].EventId {
+		t.Errorf("Expected distinct event ids, got %q and %q", hooks[0].EventId, hooks[1].EventId)
+	}
+	if hooks[0].Timestamp.Unix() != 1433103519 {
+		t.Errorf("Expected timestamp to be set, got %v", hooks[0].Timestamp)
+	}
+
+	again, err := m.UnmarshalPosthook(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].EventId != hooks[0].EventId {
+		t.Errorf("Expected event id to be stable across redeliveries")
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	attached, inlined := attachments(mmailer.Email{Attachments: []mmailer.Attachment{
+		{Name: "invoice.pdf", Content: "cGRm", ContentType: "application/pdf"},
+		{Name: "logo.png", Content: "cG5n", ContentType: "image/png", ContentId: "logo"},
+	}})
+	if attached == nil || len(*attached) != 1 || (*attached)[0].Filename != "invoice.pdf" {
+		t.Errorf("Expected invoice.pdf to be attached, got %+v", attached)
+	}
+	if inlined == nil || len(*inlined) != 1 || (*inlined)[0].ContentID != "logo" || (*inlined)[0].Filename != "logo.png" {
+		t.Errorf("Expected logo.png to be inline as logo, got %+v", inlined)
+	}
+
+	attached, inlined = attachments(mmailer.Email{})
+	if attached != nil || inlined != nil {
+		t.Errorf("Expected no attachments, got %+v and %+v", attached, inlined)
+	}
+}
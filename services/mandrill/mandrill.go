@@ -16,13 +16,15 @@ import (
 	"github.com/google/uuid"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/keighl/mandrill"
+	"github.com/modfin/henry/slicez"
 	"github.com/modfin/mmailer"
 	"github.com/modfin/mmailer/services"
 )
 
 type Mandrill struct {
-	apiKey string
-	confer services.Configurer[*mandrill.Message]
+	apiKey  string
+	baseURL string
+	confer  services.Configurer[*mandrill.Message]
 }
 
 var httpClient = http.Client{Timeout: 60 * time.Second}
@@ -30,6 +32,9 @@ var httpClient = http.Client{Timeout: 60 * time.Second}
 func (m *Mandrill) newClient() *mandrill.Client {
 	c := mandrill.ClientWithKey(m.apiKey)
 	c.HTTPClient = &httpClient
+	if m.baseURL != "" {
+		c.BaseURL = m.baseURL
+	}
 	return c
 }
 
@@ -44,11 +49,22 @@ func (m *Mandrill) Name() string {
 	return "mandrill"
 }
 
-func (*Mandrill) CanSend(email mmailer.Email) bool {
-	return true // per domain keys not implemented
+// Limits are those of the mandrill api, 25 MB in total. Attachments have no limit of their own but the total.
+func (*Mandrill) Limits() mmailer.Limits {
+	return mmailer.Limits{MaxMessageBytes: 25 << 20}
+}
+
+func (m *Mandrill) CanSend(email mmailer.Email) bool {
+	return m.Limits().Allow(email) // per domain keys not implemented
 }
 
-func (m *Mandrill) Send(_ context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+func (m *Mandrill) Send(ctx context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+	if email.Individual {
+		return services.SendIndividually(ctx, email, m.Send)
+	}
+	if len(email.Raw) > 0 {
+		return m.sendRaw(ctx, email)
+	}
 	message := &mandrill.Message{}
 
 	services.ApplyConfig(m.Name(), email.ServiceConfig, m.confer, message)
@@ -63,6 +79,15 @@ func (m *Mandrill) Send(_ context.Context, email mmailer.Email) (res []mmailer.R
 
 	if len(email.Attachments) > 0 {
 		for _, a := range email.Attachments {
+			if a.ContentId != "" {
+				// mandrill references inline images by name, as cid:<name>
+				message.Images = append(message.Images, &mandrill.Attachment{
+					Name:    a.ContentId,
+					Content: a.Content,
+					Type:    a.ContentType,
+				})
+				continue
+			}
 			message.Attachments = append(message.Attachments, &mandrill.Attachment{
 				Name:    a.Name,
 				Content: a.Content, // should be base64 encoded
@@ -72,6 +97,7 @@ func (m *Mandrill) Send(_ context.Context, email mmailer.Email) (res []mmailer.R
 	}
 
 	message.Headers = email.Headers
+	message.Tags = email.Tags
 	message.FromName = email.From.Name
 	message.FromEmail = email.From.Email
 	message.Subject = email.Subject
@@ -95,6 +121,62 @@ func (m *Mandrill) Send(_ context.Context, email mmailer.Email) (res []mmailer.R
 
 }
 
+type rawMessage struct {
+	Key        string   `json:"key"`
+	RawMessage string   `json:"raw_message"`
+	FromEmail  string   `json:"from_email,omitempty"`
+	FromName   string   `json:"from_name,omitempty"`
+	To         []string `json:"to,omitempty"`
+	Async      bool     `json:"async"`
+}
+
+// sendRaw sends the raw message as is with messages/send-raw, which the mandrill client lacks
+func (m *Mandrill) sendRaw(ctx context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+	client := m.newClient()
+	payload, err := json.Marshal(rawMessage{
+		Key:        client.Key,
+		RawMessage: string(email.Raw),
+		FromEmail:  email.From.Email,
+		FromName:   email.From.Name,
+		To: slicez.Map(slicez.Concat(email.To, email.Cc), func(a mmailer.Address) string {
+			return a.Email
+		}),
+		Async: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Name(), err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.BaseURL+"messages/send-raw.json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Name(), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var merr mandrill.Error
+		_ = json.NewDecoder(resp.Body).Decode(&merr)
+		return nil, fmt.Errorf("%s: send-raw failed with status %d: %s", m.Name(), resp.StatusCode, merr.Message)
+	}
+	var responses []mandrill.Response
+	err = json.NewDecoder(resp.Body).Decode(&responses)
+	if err != nil {
+		return nil, fmt.Errorf("%s: could not decode response: %w", m.Name(), err)
+	}
+	for _, r := range responses {
+		res = append(res, mmailer.Response{
+			Service:   m.Name(),
+			MessageId: r.Id,
+			Email:     r.Email,
+		})
+	}
+	return res, nil
+}
+
 type posthook struct {
 	ID    string `json:"_id,omitempty"`
 	Event string `json:"event,omitempty"`
@@ -238,6 +320,7 @@ func (m *Mandrill) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 			Email:     h.Msg.Email,
 			Event:     event,
 			Info:      info,
+			Tags:      h.Msg.Tags,
 			Timestamp: time.Unix(h.Ts, 0),
 		})
 	}
@@ -1,6 +1,10 @@
 package mandrill
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
@@ -98,3 +102,39 @@ func setupMessage() (msg *mandrill.Message, originalMsg *mandrill.Message) {
 	}
 	return
 }
+
+func TestMandrill_SendRaw(t *testing.T) {
+	var got rawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages/send-raw.json" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		_, _ = w.Write([]byte(`[{"email":"to@example.com","status":"sent","_id":"abc"},{"email":"cc@example.com","status":"sent","_id":"def"}]`))
+	}))
+	defer server.Close()
+
+	m := New("secret")
+	m.baseURL = server.URL + "/"
+	raw := "From: from@example.com\r\nTo: to@example.com\r\nCc: cc@example.com\r\nSubject: hi\r\n\r\nhello\r\n"
+	res, err := m.Send(context.Background(), mmailer.Email{
+		From: mmailer.Address{Email: "from@example.com"},
+		To:   []mmailer.Address{{Email: "to@example.com"}},
+		Cc:   []mmailer.Address{{Email: "cc@example.com"}},
+		Raw:  []byte(raw),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != "secret" || got.RawMessage != raw || got.FromEmail != "from@example.com" {
+		t.Errorf("unexpected request %+v", got)
+	}
+	if !reflect.DeepEqual(got.To, []string{"to@example.com", "cc@example.com"}) {
+		t.Errorf("unexpected recipients %v", got.To)
+	}
+	if len(res) != 2 || res[0].MessageId != "abc" || res[1].Email != "cc@example.com" {
+		t.Errorf("unexpected responses %+v", res)
+	}
+}
@@ -0,0 +1,38 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+
+	"github.com/modfin/mmailer"
+	"github.com/modfin/mmailer/internal/mailbox"
+	"github.com/modfin/mmailer/services"
+)
+
+// Sandbox implements mmailer.Service by capturing the rendered messages in a mailbox instead of sending them
+type Sandbox struct {
+	box *mailbox.Mailbox
+}
+
+func New(box *mailbox.Mailbox) *Sandbox {
+	return &Sandbox{box: box}
+}
+
+func (s *Sandbox) Name() string {
+	return "sandbox"
+}
+
+func (s *Sandbox) CanSend(email mmailer.Email) bool {
+	return true
+}
+
+func (s *Sandbox) Send(ctx context.Context, email mmailer.Email) ([]mmailer.Response, error) {
+	if email.Individual {
+		return services.SendIndividually(ctx, email, s.Send)
+	}
+	return s.box.Capture(s.Name(), email)
+}
+
+func (s *Sandbox) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
+	return nil, errors.New("sandbox does not have post hooks")
+}
@@ -1,6 +1,7 @@
 package sendgrid
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"github.com/modfin/mmailer"
 	"github.com/modfin/mmailer/internal/config"
 	"github.com/modfin/mmailer/internal/logger"
+	"github.com/modfin/mmailer/internal/smtpx"
 	"github.com/modfin/mmailer/services"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -53,12 +55,27 @@ func (m *Sendgrid) Name() string {
 	return "sendgrid"
 }
 
+// Limits are those of the sendgrid v3 api, 30 MB in total. Attachments have no limit of their own but the total.
+func (m *Sendgrid) Limits() mmailer.Limits {
+	return mmailer.Limits{MaxMessageBytes: 30 << 20}
+}
+
 func (m *Sendgrid) CanSend(email mmailer.Email) bool {
 	_, ok := mmailer.KeyByEmailDomain(m.apiKeys, email.From.Email)
-	return ok
+	return ok && m.Limits().Allow(email)
 }
 
-func (m *Sendgrid) Send(_ context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+func (m *Sendgrid) Send(ctx context.Context, email mmailer.Email) (res []mmailer.Response, err error) {
+	if len(email.Raw) > 0 {
+		// sendgrid has no raw api, the message is parsed and sent as any other, to the envelope recipients
+		parsed, err := smtpx.Parse(bytes.NewReader(email.Raw))
+		if err != nil {
+			return nil, fmt.Errorf("sendgrid: could not parse raw message: %w", err)
+		}
+		parsed.From, parsed.To, parsed.Cc = email.From, email.To, email.Cc
+		parsed.ServiceConfig = email.ServiceConfig
+		email = parsed
+	}
 	client, unicodeHack, err := m.newClient(email.From.Email)
 	if err != nil {
 		return nil, err
@@ -86,33 +103,29 @@ func (m *Sendgrid) Send(_ context.Context, email mmailer.Email) (res []mmailer.R
 			message.SetHeader(k, v)
 		}
 	}
+	if len(email.Tags) > 0 {
+		message.AddCategories(email.Tags...)
+	}
 
 	if len(email.Attachments) > 0 {
 		for _, a := range email.Attachments {
-			message.AddAttachment(&mail.Attachment{
+			attachment := &mail.Attachment{
 				Content:     a.Content,
 				Filename:    a.Name,
 				Type:        a.ContentType,
 				Disposition: "attachment",
-			})
+			}
+			if a.ContentId != "" {
+				attachment.Disposition = "inline"
+				attachment.ContentID = a.ContentId
+			}
+			message.AddAttachment(attachment)
 		}
 	}
-	// Hm.. With multiple TO or CC, only one message id is returned corresponging to
-	// Message-ID header. Which is reasonable, but make things hard to track.
-	// Adding multiple personalization might be a better way, to have it act like other vendors.
-	message.Personalizations[0].To = nil
-	for _, a := range email.To {
-		message.Personalizations[0].AddTos(&mail.Email{
-			Name:    a.Name,
-			Address: a.Email,
-		})
-	}
-	for _, a := range email.Cc {
-		message.Personalizations[0].AddCCs(&mail.Email{
-			Name:    a.Name,
-			Address: a.Email,
-		})
-	}
+	// With multiple TO or CC, only one message id is returned, corresponding to the Message-ID header.
+	// Individual emails have a personalization per TO recipient instead, so that each gets a message of
+	// its own, sent by one request.
+	message.Personalizations = personalizations(email)
 	response, err := client.Send(message)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %s", m.Name(), err)
@@ -122,22 +135,53 @@ func (m *Sendgrid) Send(_ context.Context, email mmailer.Email) (res []mmailer.R
 	}
 
 	for _, id := range response.Headers["X-Message-Id"] {
-		res = append(res, mmailer.Response{
-			Service:   m.Name(),
-			MessageId: id,
-		})
+		if !email.Individual {
+			res = append(res, mmailer.Response{
+				Service:   m.Name(),
+				MessageId: id,
+			})
+			continue
+		}
+		// the events of each recipient have the message id as prefix of their sg_message_id
+		for _, to := range email.To {
+			res = append(res, mmailer.Response{
+				Service:   m.Name(),
+				MessageId: id,
+				Email:     to.Email,
+			})
+		}
 	}
 
 	return res, nil
 
 }
 
+// personalizations returns the recipients of the email, in one personalization, or one per TO recipient, each with
+// all CC recipients, if the email is individual
+func personalizations(email mmailer.Email) []*mail.Personalization {
+	address := func(a mmailer.Address) *mail.Email {
+		return &mail.Email{Name: a.Name, Address: a.Email}
+	}
+	to := [][]mmailer.Address{email.To}
+	if email.Individual {
+		to = slicez.Map(email.To, func(a mmailer.Address) []mmailer.Address {
+			return []mmailer.Address{a}
+		})
+	}
+	return slicez.Map(to, func(to []mmailer.Address) *mail.Personalization {
+		p := mail.NewPersonalization()
+		p.AddTos(slicez.Map(to, address)...)
+		p.AddCCs(slicez.Map(email.Cc, address)...)
+		return p
+	})
+}
+
 type posthook struct {
 	Email                string   `json:"email"`
 	Timestamp            int64    `json:"timestamp"`
 	SMTPID               string   `json:"smtp-id"`
 	Event                string   `json:"event"`
-	Category             []string `json:"category"`
+	Category             category `json:"category"`
 	SgEventID            string   `json:"sg_event_id"`
 	SgMessageID          string   `json:"sg_message_id"`
 	Response             string   `json:"response,omitempty"`
@@ -152,6 +196,21 @@ type posthook struct {
 	BounceClassification string   `json:"bounce_classification"`
 }
 
+// category is a string when a single category is set on the message, and an array otherwise
+type category []string
+
+func (c *category) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*c = category{one}
+		return nil
+	}
+	var many []string
+	err := json.Unmarshal(data, &many)
+	*c = many
+	return err
+}
+
 func (m *Sendgrid) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 	var hooks []posthook
 	err := json.Unmarshal(body, &hooks)
@@ -207,6 +266,7 @@ func (m *Sendgrid) UnmarshalPosthook(body []byte) ([]mmailer.Posthook, error) {
 			Email:     h.Email,
 			Event:     event,
 			Info:      info,
+			Tags:      h.Category,
 			Timestamp: time.Unix(h.Timestamp, 0), // unfortunately, sendgrid only provides whole second precision
 		})
 	}
@@ -232,3 +232,55 @@ func setupMessage() (message *mail.SGMailV3, originalMessage *mail.SGMailV3) {
 	originalMessage.SetIPPoolID("initial_pool")
 	return
 }
+
+func TestSendgrid_UnmarshalPosthook_Category(t *testing.T) {
+	m := New(nil)
+	hooks, err := m.UnmarshalPosthook([]byte(`[
+{"email":"a@example.com","timestamp":1513299569,"event":"delivered","category":"newsletter","sg_event_id":"1","sg_message_id":"m1.filter"},
+{"email":"b@example.com","timestamp":1513299569,"event":"open","category":["newsletter","weekly"],"sg_event_id":"2","sg_message_id":"m2.filter"},
+{"email":"c@example.com","timestamp":1513299569,"event":"open","sg_event_id":"3","sg_message_id":"m3.filter"}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"newsletter"}, {"newsletter", "weekly"}, nil}
+	for i, h := range hooks {
+		if !reflect.DeepEqual(h.Tags, expected[i]) {
+			t.Errorf("Expected tags %v, got %v", expected[i], h.Tags)
+		}
+	}
+}
+
+func TestPersonalizations(t *testing.T) {
+	email := mmailer.Email{
+		To: []mmailer.Address{{Email: "a@example.com"}, {Email: "b@example.com", Name: "B"}},
+		Cc: []mmailer.Address{{Email: "cc@example.com"}},
+	}
+	recipients := func(ps []*mail.Personalization) [][]string {
+		var res [][]string
+		for _, p := range ps {
+			var r []string
+			for _, to := range p.To {
+				r = append(r, "to:"+to.Address)
+			}
+			for _, cc := range p.CC {
+				r = append(r, "cc:"+cc.Address)
+			}
+			res = append(res, r)
+		}
+		return res
+	}
+
+	got := recipients(personalizations(email))
+	expected := [][]string{{"to:a@example.com", "to:b@example.com", "cc:cc@example.com"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	email.Individual = true
+	got = recipients(personalizations(email))
+	expected = [][]string{{"to:a@example.com", "cc:cc@example.com"}, {"to:b@example.com", "cc:cc@example.com"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected a personalization per recipient, with the cc of all, %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,95 @@
+package mmailer
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// ErrTooLarge is returned by Facade.Send when the email is larger than what the services can send
+var ErrTooLarge = errors.New("email is too large for the available services")
+
+// Limits are the max sizes a service accepts, zero is no limit
+type Limits struct {
+	// MaxMessageBytes is the max size of the encoded message, as estimated by Email.Size
+	MaxMessageBytes int
+	// MaxAttachmentBytes is the max size of each attachment, decoded. The vendors only limit the total, so it is
+	// only set for generic smtp servers, by max_attachment_size.
+	MaxAttachmentBytes int
+}
+
+// Allow is true if the email is within the limits
+func (l Limits) Allow(e Email) bool {
+	if l.MaxMessageBytes > 0 && e.Size() > l.MaxMessageBytes {
+		return false
+	}
+	if l.MaxAttachmentBytes > 0 {
+		for _, a := range e.Attachments {
+			if base64.StdEncoding.DecodedLen(len(a.Content)) > l.MaxAttachmentBytes {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Limited is implemented by services that have size limits, which their CanSend enforces
+type Limited interface {
+	Limits() Limits
+}
+
+// Unwrapper is implemented by services that decorate another service
+type Unwrapper interface {
+	Unwrap() Service
+}
+
+// LimitsOf returns the limits of a service, looking through the decorators wrapping it
+func LimitsOf(s Service) (Limits, bool) {
+	for s != nil {
+		if l, ok := s.(Limited); ok {
+			return l.Limits(), true
+		}
+		u, ok := s.(Unwrapper)
+		if !ok {
+			break
+		}
+		s = u.Unwrap()
+	}
+	return Limits{}, false
+}
+
+// Size is an estimate of the size of the email as a MIME message, with quoted-printable bodies and
+// base64 attachments broken into lines. The size of a raw email is exact.
+func (e Email) Size() int {
+	if len(e.Raw) > 0 {
+		return len(e.Raw)
+	}
+	// Date, Message-ID, MIME-Version and the boundaries and headers of the parts
+	size := 512
+	size += len("From: \r\n") + len(e.From.String())
+	size += len("Subject: \r\n") + quotedPrintableSize(e.Subject)
+	for _, a := range append(append([]Address{}, e.To...), e.Cc...) {
+		size += len(a.String()) + len(", \r\n")
+	}
+	for k, v := range e.Headers {
+		size += len(k) + len(": \r\n") + quotedPrintableSize(v)
+	}
+	size += quotedPrintableSize(e.Text) + quotedPrintableSize(e.Html)
+	for _, a := range e.Attachments {
+		// the content is base64 already, lines are 76 characters and \r\n
+		size += 256 + len(a.Name) + len(a.ContentType) + len(a.Content) + len(a.Content)/76*2
+	}
+	return size
+}
+
+func quotedPrintableSize(s string) int {
+	size := 0
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '=' || c > '~' || (c < ' ' && c != '\r' && c != '\n' && c != '\t') {
+			size += 3
+			continue
+		}
+		size++
+	}
+	// soft line breaks of =\r\n every 76 characters
+	return size + size/76*3
+}
@@ -0,0 +1,80 @@
+package mmailer
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type limitedService struct {
+	name   string
+	limits Limits
+}
+
+func (l *limitedService) Name() string             { return l.name }
+func (l *limitedService) Limits() Limits           { return l.limits }
+func (l *limitedService) CanSend(email Email) bool { return l.limits.Allow(email) }
+func (l *limitedService) Send(ctx context.Context, email Email) ([]Response, error) {
+	return []Response{{Service: l.name}}, nil
+}
+func (l *limitedService) UnmarshalPosthook(body []byte) ([]Posthook, error) { return nil, nil }
+
+type wrapper struct {
+	Service
+}
+
+func (w *wrapper) Unwrap() Service { return w.Service }
+
+func withAttachment(size int) Email {
+	e := validEmail()
+	e.Attachments = []Attachment{{Name: "a.bin", Content: base64.StdEncoding.EncodeToString(make([]byte, size))}}
+	return e
+}
+
+func TestEmail_Size(t *testing.T) {
+	small := validEmail().Size()
+	large := withAttachment(1 << 20).Size()
+	assert.Greater(t, large-small, 4<<20/3, "attachments count base64 encoded")
+	assert.Less(t, large-small, 4<<20/3+(4<<20/3)/20)
+
+	e := validEmail()
+	e.Text = strings.Repeat("å", 1000)
+	assert.Greater(t, e.Size()-small, 6000, "non ascii text is quoted-printable encoded")
+
+	raw := []byte("From: a@example.com\r\n\r\nHi")
+	assert.Equal(t, len(raw), Email{Raw: raw}.Size())
+}
+
+func TestLimits_Allow(t *testing.T) {
+	assert.True(t, Limits{}.Allow(withAttachment(1<<20)))
+	assert.False(t, Limits{MaxMessageBytes: 1 << 20}.Allow(withAttachment(1<<20)))
+	assert.True(t, Limits{MaxMessageBytes: 2 << 20}.Allow(withAttachment(1<<20)))
+	assert.False(t, Limits{MaxAttachmentBytes: 1<<20 - 1}.Allow(withAttachment(1<<20)))
+}
+
+func TestLimitsOf(t *testing.T) {
+	s := &limitedService{name: "a", limits: Limits{MaxMessageBytes: 10}}
+	l, ok := LimitsOf(&wrapper{&wrapper{s}})
+	assert.True(t, ok)
+	assert.Equal(t, s.limits, l)
+
+	_, ok = LimitsOf(&wrapper{})
+	assert.False(t, ok)
+}
+
+func TestFacade_SendTooLarge(t *testing.T) {
+	small := &limitedService{name: "small", limits: Limits{MaxMessageBytes: 1 << 20}}
+	large := &limitedService{name: "large", limits: Limits{MaxMessageBytes: 4 << 20}}
+	f := New(SelectRandom, RetryNone, &wrapper{small}, &wrapper{large})
+
+	res, err := f.Send(context.Background(), withAttachment(1<<20), "")
+	require.NoError(t, err)
+	assert.Equal(t, "large", res[0].Service, "only the service that can carry it is selected")
+
+	_, err = f.Send(context.Background(), withAttachment(4<<20), "")
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -0,0 +1,202 @@
+package mmailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/modfin/henry/slicez"
+)
+
+// MaxSubjectLength is the max length of a header line, RFC 5322 2.1.1
+const MaxSubjectLength = 998
+
+// MaxAttachmentsSize is the max total size, decoded, of the attachments of an email
+const MaxAttachmentsSize = 40 << 20
+
+// FieldError is a problem with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in an email
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (v *ValidationError) Error() string {
+	return "invalid email: " + strings.Join(slicez.Map(v.Fields, func(f FieldError) string {
+		return f.Field + ": " + f.Message
+	}), ", ")
+}
+
+func (v *ValidationError) add(field string, format string, args ...any) {
+	v.Fields = append(v.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks that the email can be sent, it returns a *ValidationError listing all problems found
+func (e Email) Validate() error {
+	v := &ValidationError{}
+
+	validateAddress(v, "from", e.From)
+	if len(e.To) == 0 {
+		v.add("to", "at least one recipient is required")
+	}
+	for i, a := range e.To {
+		validateAddress(v, fmt.Sprintf("to[%d]", i), a)
+	}
+	for i, a := range e.Cc {
+		validateAddress(v, fmt.Sprintf("cc[%d]", i), a)
+	}
+	if e.Priority != "" && !slicez.Contains(Priorities, e.Priority) {
+		v.add("priority", "must be one of critical, normal and bulk")
+	}
+	for i, c := range e.ServiceConfig {
+		if c.Key == Hedge && c.Value != "" {
+			if d, err := time.ParseDuration(c.Value); err != nil || d < 0 {
+				v.add(fmt.Sprintf("service_config[%d].value", i), "is not a duration, eg. 500ms")
+			}
+		}
+	}
+
+	if len(e.Raw) > 0 {
+		validateRaw(v, e)
+		if len(v.Fields) > 0 {
+			return v
+		}
+		return nil
+	}
+
+	switch {
+	case strings.TrimSpace(e.Subject) == "":
+		v.add("subject", "is required")
+	case len(e.Subject) > MaxSubjectLength:
+		v.add("subject", "is longer than %d characters", MaxSubjectLength)
+	case strings.ContainsAny(e.Subject, "\r\n"):
+		v.add("subject", "must not contain line breaks")
+	}
+	if strings.TrimSpace(e.Text) == "" && strings.TrimSpace(e.Html) == "" {
+		v.add("text", "text or html is required")
+	}
+
+	for k, val := range e.Headers {
+		field := "headers." + k
+		if !validHeaderName(k) {
+			v.add(field, "is not a valid header name")
+		}
+		if strings.ContainsAny(val, "\r\n") {
+			v.add(field, "must not contain line breaks")
+		}
+	}
+
+	var size int
+	for i, a := range e.Attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+		references := 0
+		if a.URL != "" {
+			references++
+			if u, err := url.Parse(a.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.add(field+".url", "is not a valid http url")
+			}
+		}
+		if a.ObjectKey != "" {
+			references++
+		}
+		if references > 1 || (references > 0 && a.Content != "") {
+			v.add(field, "must have only one of content, url and object_key")
+		}
+		if strings.TrimSpace(a.Name) == "" && references == 0 {
+			// referenced attachments may be named by what is fetched
+			v.add(field+".name", "is required")
+		}
+		if strings.ContainsAny(a.Name, "\r\n\"") {
+			v.add(field+".name", "must not contain line breaks or quotes")
+		}
+		if a.ContentType != "" {
+			if _, _, err := mime.ParseMediaType(a.ContentType); err != nil {
+				v.add(field+".content_type", "is not a valid media type")
+			}
+		}
+		if strings.ContainsAny(a.ContentId, "\r\n<> ") {
+			v.add(field+".content_id", "must not contain line breaks, spaces or angle brackets")
+		}
+		data, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			v.add(field+".content", "is not valid base64")
+		}
+		size += len(data)
+	}
+	if size > MaxAttachmentsSize {
+		v.add("attachments", "are %d bytes, max is %d", size, MaxAttachmentsSize)
+	}
+
+	if len(v.Fields) > 0 {
+		return v
+	}
+	return nil
+}
+
+func validateRaw(v *ValidationError, e Email) {
+	if e.Text != "" || e.Html != "" {
+		v.add("raw", "text and html must be empty when raw is given")
+	}
+	if len(e.Attachments) > 0 {
+		v.add("raw", "attachments must be empty when raw is given")
+	}
+	if len(e.Headers) > 0 {
+		v.add("raw", "headers must be empty when raw is given")
+	}
+	if _, err := mail.ReadMessage(bytes.NewReader(e.Raw)); err != nil {
+		v.add("raw", "is not a valid message: %v", err)
+	}
+}
+
+func validateAddress(v *ValidationError, field string, a Address) {
+	if strings.ContainsAny(a.Name, "\r\n") {
+		v.add(field+".name", "must not contain line breaks")
+	}
+	if strings.TrimSpace(a.Email) == "" {
+		v.add(field+".email", "is required")
+		return
+	}
+	parsed, err := mail.ParseAddress(a.Email)
+	if err != nil || parsed.Address != strings.TrimSpace(a.Email) {
+		v.add(field+".email", "is not a valid address")
+	}
+}
+
+// validHeaderName checks that name is printable ascii without colon, RFC 5322 2.2
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// Error is the json body of error responses from mmailerd
+type Error struct {
+	// Status is the http status of the response
+	Status  int          `json:"-"`
+	Message string       `json:"error"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	// Report is the services tried, when asked for
+	Report *SendReport `json:"report,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("mmailer responded %d: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("mmailer responded %d: %s, %s", e.Status, e.Message, (&ValidationError{Fields: e.Fields}).Error())
+}
@@ -0,0 +1,97 @@
+package mmailer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validEmail() Email {
+	e := NewEmail()
+	e.From = Address{Name: "Jon Doe", Email: "jon@example.com"}
+	e.To = []Address{{Email: "jane@example.com"}}
+	e.Subject = "Hello"
+	e.Text = "Hi"
+	e.Headers["X-Campaign"] = "autumn"
+	e.Attachments = []Attachment{{Name: "a.txt", Content: "aGVsbG8=", ContentType: "text/plain; charset=utf-8"}}
+	return e
+}
+
+func TestEmail_Validate(t *testing.T) {
+	assert.NoError(t, validEmail().Validate())
+
+	referenced := validEmail()
+	referenced.Attachments = []Attachment{{URL: "https://files.example.com/report.pdf"}, {ObjectKey: "reports/q3.pdf"}}
+	assert.NoError(t, referenced.Validate(), "referenced attachments are named when fetched")
+
+	tests := []struct {
+		name   string
+		modify func(e *Email)
+		fields []string
+	}{
+		{"no recipients", func(e *Email) { e.To = nil }, []string{"to"}},
+		{"bad from", func(e *Email) { e.From.Email = "jon at example.com" }, []string{"from.email"}},
+		{"address with name", func(e *Email) { e.To[0].Email = "Jane <jane@example.com>" }, []string{"to[0].email"}},
+		{"bad cc", func(e *Email) { e.Cc = []Address{{Email: ""}} }, []string{"cc[0].email"}},
+		{"name injection", func(e *Email) { e.From.Name = "Jon\r\nBcc: x@example.com" }, []string{"from.name"}},
+		{"no subject", func(e *Email) { e.Subject = " " }, []string{"subject"}},
+		{"long subject", func(e *Email) { e.Subject = strings.Repeat("a", 999) }, []string{"subject"}},
+		{"subject injection", func(e *Email) { e.Subject = "Hi\nBcc: x@example.com" }, []string{"subject"}},
+		{"no body", func(e *Email) { e.Text = "" }, []string{"text"}},
+		{"header injection", func(e *Email) { e.Headers["X-Campaign"] = "a\r\nBcc: x@example.com" }, []string{"headers.X-Campaign"}},
+		{"bad header name", func(e *Email) { e.Headers["X Campaign:"] = "a" }, []string{"headers.X Campaign:"}},
+		{"bad base64", func(e *Email) { e.Attachments[0].Content = "not base64!" }, []string{"attachments[0].content"}},
+		{"bad content type", func(e *Email) { e.Attachments[0].ContentType = "text/" }, []string{"attachments[0].content_type"}},
+		{"bad priority", func(e *Email) { e.Priority = "urgent" }, []string{"priority"}},
+		{"bad hedge threshold", func(e *Email) {
+			e.ServiceConfig = []ConfigItem{{Key: Hedge, Value: "soon"}}
+		}, []string{"service_config[0].value"}},
+		{"bad content id", func(e *Email) { e.Attachments[0].ContentId = "<logo>" }, []string{"attachments[0].content_id"}},
+		{"content and url", func(e *Email) { e.Attachments[0].URL = "https://files.example.com/a.txt" }, []string{"attachments[0]"}},
+		{"bad url", func(e *Email) {
+			e.Attachments[0] = Attachment{URL: "file:///etc/passwd"}
+		}, []string{"attachments[0].url"}},
+		{"url and object key", func(e *Email) {
+			e.Attachments[0] = Attachment{URL: "https://files.example.com/a.txt", ObjectKey: "a.txt"}
+		}, []string{"attachments[0]"}},
+		{"no attachment name", func(e *Email) { e.Attachments[0].Name = "" }, []string{"attachments[0].name"}},
+		{"several", func(e *Email) { e.To = nil; e.Subject = "" }, []string{"to", "subject"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := validEmail()
+			tc.modify(&e)
+			err := e.Validate()
+			var verr *ValidationError
+			require.ErrorAs(t, err, &verr)
+			var fields []string
+			for _, f := range verr.Fields {
+				fields = append(fields, f.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
+
+func TestEmail_ValidateRaw(t *testing.T) {
+	e := NewEmail()
+	e.From = Address{Email: "jon@example.com"}
+	e.To = []Address{{Email: "jane@example.com"}}
+	e.Raw = []byte("From: jon@example.com\r\nTo: jane@example.com\r\nSubject: Hi\r\n\r\nHello\r\n")
+	assert.NoError(t, e.Validate(), "subject and body come from the raw message")
+
+	e.Text = "Hi"
+	e.Headers["X-Campaign"] = "autumn"
+	var verr *ValidationError
+	require.ErrorAs(t, e.Validate(), &verr)
+	assert.Len(t, verr.Fields, 2)
+
+	e = NewEmail()
+	e.From = Address{Email: "jon@example.com"}
+	e.Raw = []byte("not a message")
+	require.ErrorAs(t, e.Validate(), &verr)
+	assert.Equal(t, "to", verr.Fields[0].Field)
+	assert.Equal(t, "raw", verr.Fields[1].Field)
+}